@@ -6,11 +6,16 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"html"
+	"io"
 	"log"
+	"net"
 	"net/url"
 	"os"
 	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -21,43 +26,153 @@ var (
 	cacheOut    string
 	dedupeKey   string
 	stripAssets bool
+	seenDBPath  string
+	contentKeep string
+	contentMap  string
+	ciParams    bool
+	slashMode   string
+	slashOutput bool
+	valuesOut   string
+	sinceFile   string
+	normEncode  bool
+	showStats   bool
+	recursive   bool
+	recurseMax  int
+	showCfg     bool
+	plusMode    string
+	keepEmpty   bool
+	appendOut   bool
+	maxFileSize int64 // MiB
+	verifyRepl  bool
+	rejectsFile string
+	onlyNumeric bool
+	skipNumeric bool
 )
 
 func init() {
 	flag.StringVar(&inFile, "f", "", "input file of URLs (one per line)")
+	flag.Int64Var(&maxFileSize, "max-file-size", 512, "Refuse input files larger than this many MiB, and cap piped input at it (0 = no limit)")
 	flag.StringVar(&outFile, "o", "out.txt", "output file of mutated URLs")
+	flag.BoolVar(&appendOut, "append", false, "append to -o, --cache and --values-out instead of overwriting them (pair with --since-file or --seen-db to keep batches distinct)")
 	flag.StringVar(&cacheOut, "cache", "param_urls.txt", "optional cache of parameterized URLs before mutation")
 	flag.StringVar(&dedupeKey, "dedupe", "url", "dedupe mode: url|path+keys (controls how duplicates are detected)")
 	flag.BoolVar(&stripAssets, "no-assets", true, "drop static asset URLs (js, css, images, fonts, media) before mutation")
+	flag.BoolVar(&ciParams, "ci-params", false, "with --dedupe path+keys, compare parameter names case-insensitively (Id == id); output keeps original casing")
+	flag.StringVar(&slashMode, "slash", "none", "trailing-slash normalization for dedupe: none|strip (/a/ -> /a)|append (/a -> /a/, extension-less paths only); root / is never stripped")
+	flag.BoolVar(&slashOutput, "slash-output", false, "apply --slash to written URLs too, not just the dedupe signature")
+	flag.StringVar(&valuesOut, "values-out", "", "optional file collecting distinct original (decoded) values of non-blacklisted params, for fuzzing wordlists")
+	flag.StringVar(&plusMode, "plus", "form", "how '+' in query values is read: form (space, as x-www-form-urlencoded servers do; a+b == a%20b) | raw (a literal plus, for servers that only percent-decode)")
+	flag.BoolVar(&keepEmpty, "keep-empty-values", false, "also keep URLs whose params are all bare keys (?debug, ?a&b); they get markers like empty values (key= already passes and becomes key=LAKSH1)")
+	flag.BoolVar(&normEncode, "normalize-encoding", false, "decode query keys/values once (+ vs %20 per --plus) and re-encode them consistently before dedupe; double-encoded values keep their outer layer")
+	flag.BoolVar(&recursive, "recursive", false, "also mutate the params of values that decode to URLs with their own query (?next=https%3A%2F%2Fx%2F%3Fa%3D1)")
+	flag.IntVar(&recurseMax, "recursive-depth", 2, "with --recursive, how many levels of nested URLs to descend into")
+	flag.StringVar(&contentKeep, "content", "", "keep only URLs whose likely content class is listed: html,api,asset,doc,other (empty keeps all)")
+	flag.StringVar(&contentMap, "content-map", "", "override extension classes, e.g. .do=api,.txt=html (see contentClassByExt)")
+	flag.StringVar(&sinceFile, "since-file", "", "previous run's output or cache file; URLs already in it are skipped so only the delta is written")
+	flag.BoolVar(&onlyNumeric, "only-numeric-values", false, "mutate only params whose original value is a number (id=42); other params keep their value, and URLs left with none are dropped")
+	flag.BoolVar(&skipNumeric, "skip-numeric-values", false, "leave params whose original value is a number unmutated, focusing on string/token params; URLs left with none are dropped")
+	flag.BoolVar(&verifyRepl, "verify-replacement", false, "re-parse every mutated URL and check it has exactly one LAKSH marker per mutable param and the original param count; failures go to --rejects instead of -o")
+	flag.StringVar(&rejectsFile, "rejects", "rejects.txt", "with --verify-replacement, file of mutated URLs that failed the check, each followed by the reason")
+	flag.BoolVar(&showStats, "stats", false, "print a breakdown of why input lines were dropped to stderr")
+	flag.BoolVar(&showCfg, "show-config", false, "print the effective settings to stderr and exit")
+	flag.StringVar(&seenDBPath, "seen-db", "", "optional file of dedupe signatures persisted across runs; known signatures are skipped and new ones appended")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		runMerge(os.Args[2:])
+		return
+	}
 	flag.Parse()
+	if showCfg {
+		showConfig()
+		return
+	}
 	if inFile == "" {
-		log.Fatal("usage: go run greper.go -f urls.txt [-o out.txt] [--cache param_urls.txt] [--dedupe url|path+keys] [--no-assets=true]")
+		log.Fatal("usage: go run greper.go -f urls.txt [-o out.txt] [--cache param_urls.txt] [--append] [--dedupe url|path+keys [--ci-params]] [--slash none|strip|append [--slash-output]] [--no-assets=true] [--normalize-encoding] [--plus form|raw] [--keep-empty-values] [--seen-db seen.txt] [--since-file prev.txt] [--content html,api] [--content-map .ext=class] [--values-out values.txt] [--recursive [--recursive-depth N]] [--only-numeric-values|--skip-numeric-values] [--verify-replacement [--rejects rejects.txt]] [--stats] [--show-config]\n       go run greper.go merge [-o merged.txt] [-normalize] [-count] [-disk] files...")
+	}
+
+	switch plusMode {
+	case "form", "raw":
+	default:
+		log.Fatalf("invalid --plus %q (use form|raw)", plusMode)
+	}
+	if onlyNumeric && skipNumeric {
+		log.Fatal("--only-numeric-values and --skip-numeric-values are mutually exclusive")
+	}
+	if recursive && recurseMax < 1 {
+		log.Fatalf("invalid --recursive-depth %d (must be at least 1)", recurseMax)
+	}
+
+	switch slashMode {
+	case "none", "strip", "append":
+	default:
+		log.Fatalf("invalid --slash %q (use none|strip|append)", slashMode)
 	}
 
+	keepClasses, classByExt, err := parseContentFlags(contentKeep, contentMap)
+	if err != nil {
+		log.Fatalf("content: %v", err)
+	}
+	var valuesBuf bytes.Buffer
+	valuesSeen := make(map[string]struct{})
+	classKept := make(map[string]int)
+	classDropped := make(map[string]int)
+
 	in, err := os.Open(inFile)
 	if err != nil {
 		log.Fatalf("open input: %v", err)
 	}
 	defer in.Close()
+	input, err := guardInput(in)
+	if err != nil {
+		log.Fatalf("open input: %v", err)
+	}
 
 	var cacheBuf bytes.Buffer
 	var outBuf bytes.Buffer
+	var rejectsBuf bytes.Buffer
 
-	sc := bufio.NewScanner(in)
+	sc := bufio.NewScanner(input)
 	const maxLine = 2 * 1024 * 1024
 	buf := make([]byte, 0, 128*1024)
 	sc.Buffer(buf, maxLine)
 
 	seen := make(map[string]struct{}) // dedupe set
 
+	// Signatures from previous runs; skipped like duplicates but counted apart
+	known := make(map[string]struct{})
+	var seenDB *os.File
+	if seenDBPath != "" {
+		known, err = loadSeenDB(seenDBPath)
+		if err != nil {
+			log.Fatalf("load seen-db: %v", err)
+		}
+		seenDB, err = os.OpenFile(seenDBPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("open seen-db: %v", err)
+		}
+		defer seenDB.Close()
+	}
+	skippedKnown := 0
+
+	// Signatures from a single reference file (previous output or cache)
+	var prior map[string]struct{}
+	if sinceFile != "" {
+		prior, err = loadSinceFile(sinceFile)
+		if err != nil {
+			log.Fatalf("load since-file: %v", err)
+		}
+	}
+	skippedPrior := 0
+	var st dropStats
+
 	for sc.Scan() {
 		line := strings.TrimSpace(sc.Text())
 		if line == "" {
 			continue
 		}
+		st.read++
 
 		// Step 1: HTML entity unescape (&amp; -> &)
 		unescaped := html.UnescapeString(line)
@@ -65,31 +180,92 @@ func main() {
 		// Parse; skip non-URLs
 		u, err := url.Parse(unescaped)
 		if err != nil || u.Scheme == "" || u.Host == "" {
+			st.parse++
 			continue
 		}
 
-		// Must have at least one key=value query pair
-		if !hasKeyValueQuery(u.RawQuery) {
+		// Optional: one canonical percent-encoding per query so dedupe sees through it
+		if normEncode {
+			u.RawQuery = normalizeQueryEncoding(u.RawQuery)
+		}
+
+		// Must have at least one key=value query pair (or, with --keep-empty-values, any key)
+		if !hasParams(u.RawQuery) {
+			st.noQuery++
 			continue
 		}
 
+		// Canonical trailing slash for written URLs, if requested
+		if slashOutput && slashMode != "none" {
+			normalizeSlash(u, slashMode)
+		}
+
 		// Dedup BEFORE mutation
 		key := dedupeSignature(u, dedupeKey)
+		if _, ok := known[key]; ok {
+			skippedKnown++
+			continue
+		}
 		if _, ok := seen[key]; ok {
+			st.duplicate++
 			continue
 		}
 		seen[key] = struct{}{}
+		if prior != nil && inSinceFile(prior, u, key) {
+			skippedPrior++
+			continue
+		}
+		if seenDB != nil {
+			if _, err := seenDB.WriteString(key + "\n"); err != nil {
+				log.Fatalf("write seen-db: %v", err)
+			}
+		}
+
+		// Optional: keep only the requested content classes. This runs
+		// first so a class kept by name (--content api keeps .json) is not
+		// lost to --no-assets
+		class := ""
+		if keepClasses != nil {
+			class = contentClass(u.Path, classByExt)
+			if _, ok := keepClasses[class]; !ok {
+				classDropped[class]++
+				st.content++
+				continue
+			}
+		}
 
 		// Optional: filter out static assets BEFORE mutation
-		if stripAssets && looksLikeAsset(u.Path) {
+		if stripAssets && class == "" && looksLikeAsset(u.Path) {
+			st.asset++
 			continue
 		}
 
 		// Skip URLs whose query is composed entirely of blacklisted analytics params
 		if !hasAnyNonBlacklistedKey(u.RawQuery) {
+			st.blacklisted++
+			continue
+		}
+		if class != "" {
+			classKept[class]++
+		}
+
+		if (onlyNumeric || skipNumeric) && countMutable(u.RawQuery, mutateDepth()) == 0 {
+			st.numeric++
 			continue
 		}
 
+		// Harvest original values before mutation replaces them
+		if valuesOut != "" {
+			for _, v := range paramValues(u.RawQuery) {
+				if _, ok := valuesSeen[v]; ok {
+					continue
+				}
+				valuesSeen[v] = struct{}{}
+				valuesBuf.WriteString(v)
+				valuesBuf.WriteByte('\n')
+			}
+		}
+
 		// Cache original (post-unescape) parameterized URL
 		cacheBuf.WriteString(u.String())
 		cacheBuf.WriteByte('\n')
@@ -97,6 +273,13 @@ func main() {
 		// Mutate only non-blacklisted params
 		mut := *u
 		mut.RawQuery = mutateQueryRaw(u.RawQuery)
+		if verifyRepl {
+			if err := verifyMutation(u, mut.String()); err != nil {
+				fmt.Fprintf(&rejectsBuf, "%s\t%v\n", mut.String(), err)
+				st.rejected++
+				continue
+			}
+		}
 		outBuf.WriteString(mut.String())
 		outBuf.WriteByte('\n')
 	}
@@ -105,14 +288,38 @@ func main() {
 	}
 
 	// write outputs
+	var appended []string
+	note := func(p string, data []byte, had int) {
+		if appendOut {
+			appended = append(appended, fmt.Sprintf("%s: +%d lines (had %d)", p, bytes.Count(data, []byte{'\n'}), had))
+		}
+	}
 	if cacheOut != "" {
-		if err := os.WriteFile(cacheOut, cacheBuf.Bytes(), 0644); err != nil {
+		had, err := writeOutput(cacheOut, cacheBuf.Bytes())
+		if err != nil {
 			log.Fatalf("write cache: %v", err)
 		}
+		note(cacheOut, cacheBuf.Bytes(), had)
 	}
-	if err := os.WriteFile(outFile, outBuf.Bytes(), 0644); err != nil {
+	had, err := writeOutput(outFile, outBuf.Bytes())
+	if err != nil {
 		log.Fatalf("write out: %v", err)
 	}
+	note(outFile, outBuf.Bytes(), had)
+	if valuesOut != "" {
+		had, err := writeOutput(valuesOut, valuesBuf.Bytes())
+		if err != nil {
+			log.Fatalf("write values: %v", err)
+		}
+		note(valuesOut, valuesBuf.Bytes(), had)
+	}
+	if verifyRepl {
+		had, err := writeOutput(rejectsFile, rejectsBuf.Bytes())
+		if err != nil {
+			log.Fatalf("write rejects: %v", err)
+		}
+		note(rejectsFile, rejectsBuf.Bytes(), had)
+	}
 
 	fmt.Printf(
 		"Wrote %d mutated URLs to %s; cached %d param URLs to %s (dedupe=%s, no-assets=%v)\n",
@@ -120,6 +327,238 @@ func main() {
 		bytes.Count(cacheBuf.Bytes(), []byte{'\n'}), cacheOut,
 		dedupeKey, stripAssets,
 	)
+	if len(appended) > 0 {
+		fmt.Printf("Appended to %s\n", strings.Join(appended, "; "))
+	}
+	if seenDBPath != "" {
+		fmt.Printf("Skipped %d URLs already recorded in %s\n", skippedKnown, seenDBPath)
+	}
+	if sinceFile != "" {
+		fmt.Printf("Since %s: %d new URLs, %d already known\n", sinceFile, len(seen)-skippedPrior, skippedPrior)
+	}
+	if verifyRepl {
+		fmt.Printf("Replacement check: %d URLs failed, written to %s\n", st.rejected, rejectsFile)
+	}
+	if valuesOut != "" {
+		fmt.Printf("Collected %d unique param values to %s\n", len(valuesSeen), valuesOut)
+	}
+	if showStats {
+		st.known, st.prior = skippedKnown, skippedPrior
+		st.print(os.Stderr, bytes.Count(outBuf.Bytes(), []byte{'\n'}))
+	}
+	if keepClasses != nil {
+		fmt.Printf("Content classes kept: %s; dropped: %s\n", formatClassCounts(classKept), formatClassCounts(classDropped))
+	}
+}
+
+// dropStats counts input lines by the filter that dropped them, for -stats.
+type dropStats struct {
+	read        int
+	parse       int
+	noQuery     int
+	known       int
+	duplicate   int
+	prior       int
+	asset       int
+	blacklisted int
+	content     int
+	numeric     int
+	rejected    int
+}
+
+func (s dropStats) print(w io.Writer, written int) {
+	fmt.Fprintf(w, "Filter stats (%d non-empty lines read, %d written):\n", s.read, written)
+	rows := []struct {
+		name string
+		n    int
+	}{
+		{"parse failures / not a URL", s.parse},
+		{"no key=value query", s.noQuery},
+		{"known in --seen-db", s.known},
+		{"duplicates", s.duplicate},
+		{"already in --since-file", s.prior},
+		{"static assets", s.asset},
+		{"only blacklisted params", s.blacklisted},
+		{"content class not kept", s.content},
+		{"no params left (numeric)", s.numeric},
+		{"failed --verify-replacement", s.rejected},
+	}
+	for _, r := range rows {
+		fmt.Fprintf(w, "  %-28s %d\n", r.name, r.n)
+	}
+}
+
+// showConfig prints the effective value of every flag to stderr, marking
+// the ones left at their default.
+func showConfig() {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	fmt.Fprintln(os.Stderr, "Effective config:")
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "show-config" {
+			return
+		}
+		src := "default"
+		if set[f.Name] {
+			src = "set"
+		}
+		fmt.Fprintf(os.Stderr, "  -%-24s %-30q (%s)\n", f.Name, f.Value.String(), src)
+	})
+}
+
+// writeOutput writes data to p, replacing the file unless --append is set, in
+// which case data goes to the end. It returns how many lines p held before.
+func writeOutput(p string, data []byte) (int, error) {
+	if !appendOut {
+		return 0, os.WriteFile(p, data, 0644)
+	}
+	prior, err := countLines(p)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return prior, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return prior, err
+	}
+	return prior, f.Close()
+}
+
+// countLines counts newline-terminated lines in p; a missing file has none.
+func countLines(p string) (int, error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n := 0
+	buf := make([]byte, 64*1024)
+	for {
+		c, err := f.Read(buf)
+		n += bytes.Count(buf[:c], []byte{'\n'})
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// loadSeenDB reads one dedupe signature per line; a missing file is an empty store.
+func loadSeenDB(p string) (map[string]struct{}, error) {
+	keys := make(map[string]struct{})
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 128*1024), 2*1024*1024)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			keys[line] = struct{}{}
+		}
+	}
+	return keys, sc.Err()
+}
+
+// loadSinceFile reads the URLs of a previous run and returns their dedupe
+// signatures. Lines that are not parameterized URLs are ignored.
+func loadSinceFile(p string) (map[string]struct{}, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := guardInput(f)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]struct{})
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 128*1024), 2*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		u, err := url.Parse(html.UnescapeString(line))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			continue
+		}
+		keys[dedupeSignature(u, dedupeKey)] = struct{}{}
+	}
+	return keys, sc.Err()
+}
+
+// guardInput checks an opened input against -max-file-size before it is
+// read: regular files are refused when too large or when they look binary
+// (a NUL in the first 4 KiB); pipes are capped as they are read.
+func guardInput(f *os.File) (io.Reader, error) {
+	limit := maxFileSize << 20
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !st.Mode().IsRegular() {
+		if limit <= 0 {
+			return f, nil
+		}
+		return &cappedReader{r: f, left: limit, name: f.Name()}, nil
+	}
+	if limit > 0 && st.Size() > limit {
+		return nil, fmt.Errorf("%s is %d MiB, over -max-file-size %d MiB (raise it, or 0 to disable)", f.Name(), st.Size()>>20, maxFileSize)
+	}
+	head := make([]byte, 4096)
+	n, _ := io.ReadFull(f, head)
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		return nil, fmt.Errorf("%s looks like a binary file, not a text list", f.Name())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// cappedReader fails a stream once it passes -max-file-size.
+type cappedReader struct {
+	r    io.Reader
+	left int64
+	name string
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		return 0, fmt.Errorf("%s exceeds -max-file-size %d MiB", c.name, maxFileSize)
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	return n, err
+}
+
+// inSinceFile reports whether u was in the reference file, matching either
+// its own signature (cache files) or that of its mutated form (output files).
+func inSinceFile(prior map[string]struct{}, u *url.URL, key string) bool {
+	if _, ok := prior[key]; ok {
+		return true
+	}
+	mut := *u
+	mut.RawQuery = mutateQueryRaw(u.RawQuery)
+	_, ok := prior[dedupeSignature(&mut, dedupeKey)]
+	return ok
 }
 
 // hasKeyValueQuery checks if the raw query contains at least one key=value pair.
@@ -139,6 +578,12 @@ func hasKeyValueQuery(raw string) bool {
 	return false
 }
 
+// hasParams reports whether raw has something to mutate: a key=value pair, or
+// with --keep-empty-values any key at all.
+func hasParams(raw string) bool {
+	return hasKeyValueQuery(raw) || (keepEmpty && len(paramKeys(raw)) > 0)
+}
+
 // hasAnyNonBlacklistedKey returns true if raw query has at least one key not in the blacklist.
 func hasAnyNonBlacklistedKey(raw string) bool {
 	if raw == "" {
@@ -180,12 +625,26 @@ func isBlacklistedKey(k string) bool {
 
 // mutateQueryRaw replaces each non-blacklisted param value with LAKSH1..N.
 // Blacklisted keys retain original values; ordering and duplicates preserved.
+// With --recursive, values holding an encoded URL keep it and have its own
+// params numbered in the same sequence instead.
 func mutateQueryRaw(raw string) string {
+	idx := 1
+	return mutateQueryDepth(raw, &idx, mutateDepth())
+}
+
+// mutateDepth is how many levels of nested URLs mutation descends into.
+func mutateDepth() int {
+	if recursive {
+		return recurseMax
+	}
+	return 0
+}
+
+func mutateQueryDepth(raw string, idx *int, depth int) string {
 	if raw == "" {
 		return raw
 	}
 	parts := splitParams(raw)
-	idx := 1
 	for i, p := range parts {
 		if p == "" {
 			continue
@@ -194,13 +653,13 @@ func mutateQueryRaw(raw string) string {
 		key := kv[0]
 		// If no value present, handle based on blacklist
 		if len(kv) == 1 {
-			if isBlacklistedKey(key) {
+			if isBlacklistedKey(key) || onlyNumeric {
 				// Keep key as-is (no synthesized value)
 				parts[i] = key
 				continue
 			}
-			newVal := url.QueryEscape("LAKSH" + strconv.Itoa(idx))
-			idx++
+			newVal := url.QueryEscape("LAKSH" + strconv.Itoa(*idx))
+			*idx++
 			parts[i] = key + "=" + newVal
 			continue
 		}
@@ -211,21 +670,182 @@ func mutateQueryRaw(raw string) string {
 			parts[i] = key + "=" + val
 			continue
 		}
-		newVal := url.QueryEscape("LAKSH" + strconv.Itoa(idx))
-		idx++
+		if depth > 0 {
+			if inner, ok := nestedURL(val); ok {
+				inner.RawQuery = mutateQueryDepth(inner.RawQuery, idx, depth-1)
+				parts[i] = key + "=" + url.QueryEscape(inner.String())
+				continue
+			}
+		}
+		if numericFiltered(val) {
+			parts[i] = key + "=" + val
+			continue
+		}
+		newVal := url.QueryEscape("LAKSH" + strconv.Itoa(*idx))
+		*idx++
 		parts[i] = key + "=" + newVal
 	}
 	return strings.Join(parts, "&")
 }
 
+var markerRe = regexp.MustCompile(`LAKSH\d+`)
+
+// verifyMutation re-parses a mutated URL and checks it against the original:
+// the same number of params, and one distinct LAKSH marker per param that
+// mutateQueryRaw should have replaced (nested ones included with --recursive).
+func verifyMutation(orig *url.URL, mutated string) error {
+	m, err := url.Parse(mutated)
+	if err != nil {
+		return fmt.Errorf("does not re-parse: %v", err)
+	}
+	if got, want := len(splitParams(m.RawQuery)), len(splitParams(orig.RawQuery)); got != want {
+		return fmt.Errorf("%d params after mutation, %d before", got, want)
+	}
+	markers := make(map[string]bool)
+	for _, mk := range markerRe.FindAllString(m.RawQuery, -1) {
+		if markers[mk] {
+			return fmt.Errorf("marker %s appears twice", mk)
+		}
+		markers[mk] = true
+	}
+	if want := countMutable(orig.RawQuery, mutateDepth()); len(markers) != want {
+		return fmt.Errorf("%d markers for %d mutable params", len(markers), want)
+	}
+	return nil
+}
+
+// countMutable counts the params of raw that should receive a marker,
+// descending into nested URLs up to depth.
+func countMutable(raw string, depth int) int {
+	n := 0
+	for _, p := range splitParams(raw) {
+		key, val, hasVal := strings.Cut(p, "=")
+		if isBlacklistedKey(key) || (!hasVal && onlyNumeric) {
+			continue
+		}
+		if hasVal && depth > 0 {
+			if inner, ok := nestedURL(val); ok {
+				n += countMutable(inner.RawQuery, depth-1)
+				continue
+			}
+		}
+		if hasVal && numericFiltered(val) {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+var numericRe = regexp.MustCompile(`^[-+]?\d+(\.\d+)?$`)
+
+// numericFiltered reports whether --only-numeric-values or
+// --skip-numeric-values leaves the raw value val unmutated.
+func numericFiltered(val string) bool {
+	if !onlyNumeric && !skipNumeric {
+		return false
+	}
+	dec, err := unescapeQueryPart(val)
+	if err != nil {
+		dec = val
+	}
+	return numericRe.MatchString(dec) == skipNumeric
+}
+
+// nestedURL decodes a param value and returns it as a URL when it is an
+// absolute URL whose query has at least one mutable key=value pair.
+func nestedURL(val string) (*url.URL, bool) {
+	dec, err := unescapeQueryPart(val)
+	if err != nil || dec == val {
+		return nil, false
+	}
+	u, err := url.Parse(dec)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, false
+	}
+	if !hasKeyValueQuery(u.RawQuery) || !hasAnyNonBlacklistedKey(u.RawQuery) {
+		return nil, false
+	}
+	return u, true
+}
+
+// normalizeQueryEncoding decodes every key and value exactly once and
+// re-encodes it the same way: reserved characters stay escaped (%26 remains
+// %26, %2541 remains %2541) and spaces become %20 whether they arrived as + or
+// %20. Parts with invalid escapes are kept verbatim, and the & and ;
+// separators are left where they were.
+func normalizeQueryEncoding(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	var b strings.Builder
+	for raw != "" {
+		part, sep := raw, ""
+		if i := strings.IndexAny(raw, "&;"); i >= 0 {
+			part, sep, raw = raw[:i], raw[i:i+1], raw[i+1:]
+		} else {
+			raw = ""
+		}
+		b.WriteString(normalizeParam(part))
+		b.WriteString(sep)
+	}
+	return b.String()
+}
+
+// normalizeParam re-encodes one key[=value] pair, or returns it unchanged when
+// either side fails to decode.
+func normalizeParam(p string) string {
+	if p == "" {
+		return p
+	}
+	kv := strings.SplitN(p, "=", 2)
+	k, ok := reencodeComponent(kv[0])
+	if !ok {
+		return p
+	}
+	if len(kv) == 1 {
+		return k
+	}
+	v, ok := reencodeComponent(kv[1])
+	if !ok {
+		return p
+	}
+	return k + "=" + v
+}
+
+// reencodeComponent decodes s once (+ per --plus) and escapes it again.
+func reencodeComponent(s string) (string, bool) {
+	d, err := unescapeQueryPart(s)
+	if err != nil {
+		return s, false
+	}
+	return strings.ReplaceAll(url.QueryEscape(d), "+", "%20"), true
+}
+
 // dedupeSignature builds a dedupe key for a URL based on the chosen mode.
+// With --plus form, '+' and %20 in the query count as the same space.
 func dedupeSignature(u *url.URL, mode string) string {
+	if slashMode != "none" {
+		c := *u
+		normalizeSlash(&c, slashMode)
+		u = &c
+	}
+	if plusMode == "form" && strings.Contains(u.RawQuery, "+") {
+		c := *u
+		c.RawQuery = strings.ReplaceAll(c.RawQuery, "+", "%20")
+		u = &c
+	}
 	switch mode {
 	case "path+keys":
 		// Same path + same set of parameter names considered duplicate,
 		// regardless of values or order (helps collapse campaign duplicates).
 		keys := paramKeys(u.RawQuery)
-		return u.Scheme + "://" + u.Host + u.EscapedPath() + "|" + strings.Join(keys, "&")
+		if ciParams {
+			for i, k := range keys {
+				keys[i] = strings.ToLower(k)
+			}
+		}
+		return u.Scheme + "://" + canonicalHost(u) + u.EscapedPath() + "|" + strings.Join(keys, "&")
 	case "url":
 		// Exact URL string (post-unescape) as key.
 		return u.String()
@@ -234,6 +854,92 @@ func dedupeSignature(u *url.URL, mode string) string {
 	}
 }
 
+// normalizeSlash strips or appends the trailing slash of u's path in place.
+// An empty path becomes the root "/", which is never stripped, and append
+// skips paths whose last segment has an extension (/a.php stays /a.php).
+// Query and fragment are untouched.
+func normalizeSlash(u *url.URL, mode string) {
+	fix := func(p string) string {
+		if p == "" {
+			return "/"
+		}
+		switch mode {
+		case "strip":
+			for len(p) > 1 && strings.HasSuffix(p, "/") {
+				p = p[:len(p)-1]
+			}
+		case "append":
+			if !strings.HasSuffix(p, "/") && path.Ext(p) == "" {
+				p += "/"
+			}
+		}
+		return p
+	}
+	u.Path = fix(u.Path)
+	if u.RawPath != "" {
+		u.RawPath = fix(u.RawPath)
+	}
+}
+
+// canonicalHost lowercases the host and drops the port when it is the scheme
+// default, so example.com, EXAMPLE.com and example.com:443 (https) collapse.
+// Non-default ports are preserved and IPv6 literals keep their brackets.
+func canonicalHost(u *url.URL) string {
+	host, port := splitHostPort(u.Host)
+	host = strings.ToLower(host)
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// splitHostPort separates a URL host into host and port for hostnames, IPv4
+// and bracketed IPv6 literals. The port is empty when none is given and IPv6
+// hosts come back without brackets.
+func splitHostPort(hostport string) (host, port string) {
+	h, p, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]"), ""
+	}
+	return h, p
+}
+
+// unescapeQueryPart decodes one query key or value. The URL spec leaves '+'
+// alone, but form-encoded queries (what most frameworks parse) use it for a
+// space; --plus picks which reading applies.
+func unescapeQueryPart(s string) (string, error) {
+	if plusMode == "raw" {
+		return url.PathUnescape(s)
+	}
+	return url.QueryUnescape(s)
+}
+
+// paramValues returns the decoded, non-empty values of non-blacklisted params
+// in encountered order. Values that fail to decode are returned raw.
+func paramValues(raw string) []string {
+	var vals []string
+	for _, p := range splitParams(raw) {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) < 2 || kv[1] == "" || isBlacklistedKey(kv[0]) {
+			continue
+		}
+		v, err := unescapeQueryPart(kv[1])
+		if err != nil {
+			v = kv[1]
+		}
+		if v = strings.TrimSpace(v); v != "" && !strings.ContainsAny(v, "\r\n") {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
 // paramKeys extracts parameter names in encountered order, preserving duplicates.
 func paramKeys(raw string) []string {
 	if raw == "" {
@@ -268,3 +974,273 @@ func looksLikeAsset(p string) bool {
 		return false
 	}
 }
+
+// contentClassByExt maps path extensions to a likely content class. URLs
+// without an extension are treated as html unless apiPathRe matches, and
+// unknown extensions fall into "other". -content-map overrides entries in a
+// copy made by parseContentFlags; this table is never modified.
+var contentClassByExt = map[string]string{
+	"": "html", ".html": "html", ".htm": "html", ".shtml": "html", ".xhtml": "html",
+	".php": "html", ".asp": "html", ".aspx": "html", ".jsp": "html", ".jspx": "html",
+	".cfm": "html", ".cgi": "html", ".pl": "html", ".do": "html", ".action": "html",
+	".json": "api", ".xml": "api", ".graphql": "api", ".wsdl": "api", ".asmx": "api", ".svc": "api",
+	".js": "asset", ".mjs": "asset", ".css": "asset", ".map": "asset",
+	".png": "asset", ".jpg": "asset", ".jpeg": "asset", ".gif": "asset", ".webp": "asset", ".svg": "asset", ".ico": "asset", ".avif": "asset",
+	".mp4": "asset", ".webm": "asset", ".mp3": "asset", ".wav": "asset", ".ogg": "asset",
+	".woff": "asset", ".woff2": "asset", ".ttf": "asset", ".eot": "asset", ".otf": "asset",
+	".pdf": "doc", ".doc": "doc", ".docx": "doc", ".xls": "doc", ".xlsx": "doc", ".ppt": "doc", ".pptx": "doc",
+	".txt": "doc", ".csv": "doc", ".zip": "doc", ".gz": "doc", ".tar": "doc",
+}
+
+var contentClasses = []string{"html", "api", "asset", "doc", "other"}
+
+// apiPathRe spots API-style paths (/api/, /graphql, /rest/, /v1/ ...) that
+// are classed as api when the extension alone would say html.
+var apiPathRe = regexp.MustCompile(`(?i)/(api|graphql|rest|rpc|v\d+)(/|$)`)
+
+// contentClass returns the likely content class of a URL path, by byExt.
+func contentClass(p string, byExt map[string]string) string {
+	ext := strings.ToLower(path.Ext(p))
+	class, ok := byExt[ext]
+	if !ok {
+		return "other"
+	}
+	if class == "html" && apiPathRe.MatchString(p) {
+		return "api"
+	}
+	return class
+}
+
+// parseContentFlags validates -content and returns the classes to keep (nil
+// when no class filter was requested) and the extension table with the
+// -content-map overrides applied.
+func parseContentFlags(keep, overrides string) (map[string]struct{}, map[string]string, error) {
+	valid := func(c string) bool {
+		for _, v := range contentClasses {
+			if v == c {
+				return true
+			}
+		}
+		return false
+	}
+	byExt := make(map[string]string, len(contentClassByExt))
+	for ext, class := range contentClassByExt {
+		byExt[ext] = class
+	}
+	for _, rule := range strings.Split(overrides, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		ext, class, ok := strings.Cut(rule, "=")
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		class = strings.ToLower(strings.TrimSpace(class))
+		if !ok || !valid(class) {
+			return nil, nil, fmt.Errorf("invalid content-map rule %q (want .ext=class)", rule)
+		}
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		byExt[ext] = class
+	}
+	if strings.TrimSpace(keep) == "" {
+		return nil, byExt, nil
+	}
+	set := make(map[string]struct{})
+	for _, c := range strings.Split(keep, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		if !valid(c) {
+			return nil, nil, fmt.Errorf("unknown content class %q (use %s)", c, strings.Join(contentClasses, ","))
+		}
+		set[c] = struct{}{}
+	}
+	return set, byExt, nil
+}
+
+// formatClassCounts renders per-class counts as "html=3 api=1".
+func formatClassCounts(m map[string]int) string {
+	if len(m) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", k, m[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// runMerge implements "greper merge": it concatenates URL/variant files,
+// dropping duplicate lines, and writes a single consolidated file. Input is
+// streamed; only dedupe keys are held in memory, or with -disk they are
+// spread over temporary bucket files so memory stays bounded (output order
+// is then grouped by bucket rather than input order).
+func runMerge(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("o", "merged.txt", "output file")
+	normalize := fs.Bool("normalize", false, "dedupe on a normalized URL (case-folded host, default port dropped, fragment dropped, params sorted) instead of the exact line")
+	count := fs.Bool("count", false, "print per-file and total line statistics")
+	disk := fs.Bool("disk", false, "dedupe through on-disk buckets for inputs too large for memory")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatal("usage: go run greper.go merge [-o merged.txt] [-normalize] [-count] [-disk] files...")
+	}
+
+	keyOf := func(line string) string {
+		if *normalize {
+			return normalizedURLKey(line)
+		}
+		return line
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("create output: %v", err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	var read, written int
+	perFile := make([]int, fs.NArg())
+	if *disk {
+		read, written, err = mergeOnDisk(fs.Args(), perFile, keyOf, w)
+	} else {
+		seen := make(map[string]struct{})
+		read, err = eachMergeLine(fs.Args(), perFile, func(line string) error {
+			key := keyOf(line)
+			if _, ok := seen[key]; ok {
+				return nil
+			}
+			seen[key] = struct{}{}
+			written++
+			_, err := w.WriteString(line + "\n")
+			return err
+		})
+	}
+	if err != nil {
+		log.Fatalf("merge: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("write output: %v", err)
+	}
+
+	if *count {
+		for i, name := range fs.Args() {
+			fmt.Printf("%s: %d lines\n", name, perFile[i])
+		}
+		fmt.Printf("Total: %d lines read, %d unique, %d duplicates dropped\n", read, written, read-written)
+	}
+	fmt.Printf("Merged %d files into %s (%d unique lines)\n", fs.NArg(), *out, written)
+}
+
+// eachMergeLine streams the non-empty trimmed lines of every file to fn,
+// counting lines per file into perFile.
+func eachMergeLine(files []string, perFile []int, fn func(string) error) (int, error) {
+	total := 0
+	for i, name := range files {
+		in, err := os.Open(name)
+		if err != nil {
+			return total, err
+		}
+		sc := bufio.NewScanner(in)
+		sc.Buffer(make([]byte, 0, 128*1024), 2*1024*1024)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			}
+			perFile[i]++
+			total++
+			if err := fn(line); err != nil {
+				in.Close()
+				return total, err
+			}
+		}
+		err = sc.Err()
+		in.Close()
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+const mergeBuckets = 64
+
+// mergeOnDisk partitions lines into bucket files by key hash, so equal keys
+// share a bucket, then dedupes one bucket at a time.
+func mergeOnDisk(files []string, perFile []int, keyOf func(string) string, w *bufio.Writer) (int, int, error) {
+	dir, err := os.MkdirTemp("", "greper-merge-")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	buckets := make([]*os.File, mergeBuckets)
+	writers := make([]*bufio.Writer, mergeBuckets)
+	for i := range buckets {
+		if buckets[i], err = os.Create(fmt.Sprintf("%s/%02d", dir, i)); err != nil {
+			return 0, 0, err
+		}
+		defer buckets[i].Close()
+		writers[i] = bufio.NewWriter(buckets[i])
+	}
+
+	read, err := eachMergeLine(files, perFile, func(line string) error {
+		h := fnv.New32a()
+		h.Write([]byte(keyOf(line)))
+		_, err := writers[h.Sum32()%mergeBuckets].WriteString(line + "\n")
+		return err
+	})
+	if err != nil {
+		return read, 0, err
+	}
+
+	written := 0
+	for i, b := range buckets {
+		if err := writers[i].Flush(); err != nil {
+			return read, written, err
+		}
+		if _, err := b.Seek(0, 0); err != nil {
+			return read, written, err
+		}
+		seen := make(map[string]struct{})
+		sc := bufio.NewScanner(b)
+		sc.Buffer(make([]byte, 0, 128*1024), 2*1024*1024)
+		for sc.Scan() {
+			line := sc.Text()
+			key := keyOf(line)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			written++
+			if _, err := w.WriteString(line + "\n"); err != nil {
+				return read, written, err
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return read, written, err
+		}
+	}
+	return read, written, nil
+}
+
+// normalizedURLKey builds a dedupe key that ignores host case, default ports,
+// fragments and parameter order. Lines that are not URLs are used verbatim.
+func normalizedURLKey(line string) string {
+	u, err := url.Parse(html.UnescapeString(line))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return line
+	}
+	params := splitParams(u.RawQuery)
+	sort.Strings(params)
+	return strings.ToLower(u.Scheme) + "://" + canonicalHost(u) + u.EscapedPath() + "?" + strings.Join(params, "&")
+}
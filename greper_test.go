@@ -0,0 +1,299 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeQueryEncoding(t *testing.T) {
+	tests := []struct {
+		plus, in, want string
+	}{
+		{"form", "q=a+b", "q=a%20b"},
+		{"form", "q=a%20b", "q=a%20b"},
+		{"raw", "q=a+b", "q=a%2Bb"},
+		{"form", "q=%2541", "q=%2541"},     // double-encoded literal keeps its outer layer
+		{"form", "q=%252520", "q=%252520"}, // triple too
+		{"form", "r=%26x%3D1", "r=%26x%3D1"},
+		{"form", "a=%41;b=2", "a=A;b=2"}, // ; stays a ;
+		{"form", "a=1&&b", "a=1&&b"},
+		{"form", "flag&x=%zz", "flag&x=%zz"}, // bad escape kept verbatim
+		{"form", "", ""},
+	}
+	defer func(p string) { plusMode = p }(plusMode)
+	for _, tt := range tests {
+		plusMode = tt.plus
+		if got := normalizeQueryEncoding(tt.in); got != tt.want {
+			t.Errorf("plus=%s normalizeQueryEncoding(%q) = %q, want %q", tt.plus, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalHost(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"http://[::1]:8080/", "[::1]:8080"},
+		{"http://[::1]:80/", "[::1]"},
+		{"http://1.2.3.4:80/", "1.2.3.4"},
+		{"https://1.2.3.4:80/", "1.2.3.4:80"},
+		{"http://EXAMPLE.com/", "example.com"},
+		{"https://example.com:443/", "example.com"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := canonicalHost(u); got != tt.want {
+			t.Errorf("canonicalHost(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDedupeSignatureCaseInsensitiveParams(t *testing.T) {
+	tests := []struct {
+		ci         bool
+		a, b       string
+		wantShared bool
+	}{
+		{false, "http://x.com/p?Id=1&Q=2", "http://x.com/p?id=3&q=4", false},
+		{true, "http://x.com/p?Id=1&Q=2", "http://x.com/p?id=3&q=4", true},
+		{true, "http://x.com/p?ID=1", "http://x.com/p?iD=9", true},
+		{true, "http://x.com/p?id=1", "http://x.com/p?ids=1", false},
+		{true, "http://x.com/p?id=1", "http://x.com/P?id=1", false}, // path case still matters
+	}
+	defer func(ci bool, s, p string) { ciParams, slashMode, plusMode = ci, s, p }(ciParams, slashMode, plusMode)
+	slashMode, plusMode = "none", "form"
+	for _, tt := range tests {
+		ciParams = tt.ci
+		ua, _ := url.Parse(tt.a)
+		ub, _ := url.Parse(tt.b)
+		sa, sb := dedupeSignature(ua, "path+keys"), dedupeSignature(ub, "path+keys")
+		if (sa == sb) != tt.wantShared {
+			t.Errorf("ci=%v %q vs %q: signatures %q, %q; shared=%v, want %v", tt.ci, tt.a, tt.b, sa, sb, sa == sb, tt.wantShared)
+		}
+	}
+}
+
+func TestNormalizeSlash(t *testing.T) {
+	tests := []struct {
+		mode, in, want string
+	}{
+		{"strip", "http://x.com", "http://x.com/"},
+		{"strip", "http://x.com/", "http://x.com/"},
+		{"strip", "http://x.com/a/", "http://x.com/a"},
+		{"strip", "http://x.com/a//", "http://x.com/a"},
+		{"strip", "http://x.com/a/?q=1/", "http://x.com/a?q=1/"},
+		{"append", "http://x.com", "http://x.com/"},
+		{"append", "http://x.com/a", "http://x.com/a/"},
+		{"append", "http://x.com/a?q=1", "http://x.com/a/?q=1"},
+		{"append", "http://x.com/a.php?q=1", "http://x.com/a.php?q=1"},
+		{"append", "http://x.com/a/#frag", "http://x.com/a/#frag"},
+		{"strip", "http://x.com/a%2Fb/", "http://x.com/a%2Fb"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		normalizeSlash(u, tt.mode)
+		if got := u.String(); got != tt.want {
+			t.Errorf("normalizeSlash(%q, %s) = %q, want %q", tt.in, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestNestedURL(t *testing.T) {
+	tests := []struct {
+		val, want string
+		ok        bool
+	}{
+		{"http%3A%2F%2Fb.com%2F%3Fq%3D1", "http://b.com/?q=1", true},
+		{"https%3A%2F%2Fb.com%2Fp%3Fa%3D1%26b%3D2", "https://b.com/p?a=1&b=2", true},
+		{"http://b.com/?q=1", "", false},                      // not encoded, so not a nested value
+		{"http%3A%2F%2Fb.com%2F", "", false},                  // no query
+		{"http%3A%2F%2Fb.com%2F%3Fflag", "", false},           // no key=value
+		{"http%3A%2F%2Fb.com%2F%3Futm_source%3Dx", "", false}, // only blacklisted keys
+		{"%2Fpath%3Fq%3D1", "", false},                        // relative
+		{"plain", "", false},
+	}
+	defer func(p string) { plusMode = p }(plusMode)
+	plusMode = "form"
+	for _, tt := range tests {
+		u, ok := nestedURL(tt.val)
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("nestedURL(%q) = %q, %v, want %q, %v", tt.val, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMutateQueryDepth(t *testing.T) {
+	const (
+		oneLevel = "next=http%3A%2F%2Fb.com%2F%3Fq%3D1&x=2"
+		twoLevel = "next=http%3A%2F%2Fb.com%2F%3Fu%3Dhttp%253A%252F%252Fc.com%252F%253Fz%253D1&x=2"
+	)
+	tests := []struct {
+		raw   string
+		depth int
+		want  string
+	}{
+		{"a=1&utm_source=x&b", 0, "a=LAKSH1&utm_source=x&b=LAKSH2"},
+		{oneLevel, 0, "next=LAKSH1&x=LAKSH2"},
+		{oneLevel, 1, "next=http%3A%2F%2Fb.com%2F%3Fq%3DLAKSH1&x=LAKSH2"},
+		{twoLevel, 1, "next=http%3A%2F%2Fb.com%2F%3Fu%3DLAKSH1&x=LAKSH2"},
+		{twoLevel, 2, "next=http%3A%2F%2Fb.com%2F%3Fu%3Dhttp%253A%252F%252Fc.com%252F%253Fz%253DLAKSH1&x=LAKSH2"},
+	}
+	defer func(p string) { plusMode = p }(plusMode)
+	plusMode = "form"
+	for _, tt := range tests {
+		idx := 1
+		if got := mutateQueryDepth(tt.raw, &idx, tt.depth); got != tt.want {
+			t.Errorf("mutateQueryDepth(%q, %d) = %q, want %q", tt.raw, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestPlusMode(t *testing.T) {
+	tests := []struct {
+		plus, in, want string
+		wantErr        bool
+	}{
+		{"form", "a+b", "a b", false},
+		{"raw", "a+b", "a+b", false},
+		{"form", "a%2Bb", "a+b", false},
+		{"raw", "a%20b", "a b", false},
+		{"form", "%zz", "", true},
+		{"raw", "%zz", "", true},
+	}
+	defer func(p string) { plusMode = p }(plusMode)
+	for _, tt := range tests {
+		plusMode = tt.plus
+		got, err := unescapeQueryPart(tt.in)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("plus=%s unescapeQueryPart(%q) = %q, %v, want %q (err %v)", tt.plus, tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	sigs := []struct {
+		plus       string
+		wantShared bool
+	}{
+		{"form", true},
+		{"raw", false},
+	}
+	defer func(s string) { slashMode = s }(slashMode)
+	slashMode = "none"
+	a, _ := url.Parse("http://x.com/?q=a+b&c=d")
+	b, _ := url.Parse("http://x.com/?q=a%20b&c=d")
+	for _, tt := range sigs {
+		plusMode = tt.plus
+		if vals := strings.Join(paramValues(a.RawQuery), "|"); (vals == "a b|d") != tt.wantShared {
+			t.Errorf("plus=%s paramValues(%q) = %q", tt.plus, a.RawQuery, vals)
+		}
+		if shared := dedupeSignature(a, "url") == dedupeSignature(b, "url"); shared != tt.wantShared {
+			t.Errorf("plus=%s: a+b and a%%20b share a signature = %v, want %v", tt.plus, shared, tt.wantShared)
+		}
+	}
+}
+
+func TestKeepEmptyValues(t *testing.T) {
+	tests := []struct {
+		raw       string
+		keepEmpty bool
+		kept      bool
+		mutated   string
+	}{
+		{"a=&b=1", false, true, "a=LAKSH1&b=LAKSH2"},
+		{"a=&b=1", true, true, "a=LAKSH1&b=LAKSH2"},
+		{"a=", false, true, "a=LAKSH1"},
+		{"debug", false, false, ""},
+		{"debug", true, true, "debug=LAKSH1"},
+		{"a&b", true, true, "a=LAKSH1&b=LAKSH2"},
+		{"utm_source&a", true, true, "utm_source&a=LAKSH1"},
+		{"", true, false, ""},
+		{"&&", true, false, ""},
+	}
+	defer func(k, r bool) { keepEmpty, recursive = k, r }(keepEmpty, recursive)
+	recursive = false
+	for _, tt := range tests {
+		keepEmpty = tt.keepEmpty
+		if got := hasParams(tt.raw); got != tt.kept {
+			t.Errorf("keep-empty=%v hasParams(%q) = %v, want %v", tt.keepEmpty, tt.raw, got, tt.kept)
+		}
+		if !tt.kept {
+			continue
+		}
+		if got := mutateQueryRaw(tt.raw); got != tt.mutated {
+			t.Errorf("keep-empty=%v mutateQueryRaw(%q) = %q, want %q", tt.keepEmpty, tt.raw, got, tt.mutated)
+		}
+	}
+}
+
+func TestNumericFiltered(t *testing.T) {
+	tests := []struct {
+		only, skip bool
+		val        string
+		want       bool
+	}{
+		{false, false, "42", false},
+		{false, false, "abc", false},
+		{true, false, "42", false},
+		{true, false, "-1.5", false},
+		{true, false, "%2D3", false}, // decoded before matching
+		{true, false, "abc", true},
+		{true, false, "1e5", true},
+		{true, false, "", true},
+		{false, true, "42", true},
+		{false, true, "%2B7", true},
+		{false, true, "+7", false}, // form-decodes to " 7"
+		{false, true, "abc", false},
+		{false, true, "4a", false},
+	}
+	defer func(p string, only, skip bool) { plusMode, onlyNumeric, skipNumeric = p, only, skip }(plusMode, onlyNumeric, skipNumeric)
+	plusMode = "form"
+	for _, tt := range tests {
+		onlyNumeric, skipNumeric = tt.only, tt.skip
+		if got := numericFiltered(tt.val); got != tt.want {
+			t.Errorf("only=%v skip=%v numericFiltered(%q) = %v, want %v", tt.only, tt.skip, tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestCountMutable(t *testing.T) {
+	const nested = "next=http%3A%2F%2Fb.com%2F%3Fq%3D1%26r%3Dx&id=7"
+	tests := []struct {
+		only, skip bool
+		raw        string
+		depth      int
+		want       int
+	}{
+		{false, false, "a=1&b=x&utm_source=z&flag", 0, 3},
+		{true, false, "a=1&b=x&utm_source=z&flag", 0, 1},
+		{false, true, "a=1&b=x&utm_source=z&flag", 0, 2},
+		{false, false, nested, 0, 2},
+		{false, false, nested, 1, 3},
+		{true, false, nested, 0, 1},
+		{true, false, nested, 1, 2},
+		{false, true, nested, 1, 1},
+	}
+	defer func(only, skip, rec bool, max int) {
+		onlyNumeric, skipNumeric, recursive, recurseMax = only, skip, rec, max
+	}(onlyNumeric, skipNumeric, recursive, recurseMax)
+	for _, tt := range tests {
+		onlyNumeric, skipNumeric = tt.only, tt.skip
+		if got := countMutable(tt.raw, tt.depth); got != tt.want {
+			t.Errorf("only=%v skip=%v countMutable(%q, %d) = %d, want %d", tt.only, tt.skip, tt.raw, tt.depth, got, tt.want)
+		}
+		// mutation must place exactly that many markers
+		recursive, recurseMax = tt.depth > 0, tt.depth
+		if got := len(markerRe.FindAllString(mutateQueryRaw(tt.raw), -1)); got != tt.want {
+			t.Errorf("only=%v skip=%v depth=%d: mutateQueryRaw(%q) placed %d markers, want %d", tt.only, tt.skip, tt.depth, tt.raw, got, tt.want)
+		}
+	}
+}
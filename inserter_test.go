@@ -0,0 +1,191 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		in, host, port string
+	}{
+		{"[::1]:8080", "::1", "8080"},
+		{"[::1]", "::1", ""},
+		{"1.2.3.4:80", "1.2.3.4", "80"},
+		{"example.com", "example.com", ""},
+	}
+	for _, tt := range tests {
+		host, port := splitHostPort(tt.in)
+		if host != tt.host || port != tt.port {
+			t.Errorf("splitHostPort(%q) = %q, %q, want %q, %q", tt.in, host, port, tt.host, tt.port)
+		}
+	}
+}
+
+func TestEmitOutputTemplate(t *testing.T) {
+	tests := []struct {
+		tpl, tag string
+		want     []string
+	}{
+		{"{{.URL}}", "off", []string{"http://a.com/?x=1", "http://b.com:8080/?y=2"}},
+		{"{{.Index}},{{.Template}},{{.URL}}", "off", []string{"1,cmd,http://a.com/?x=1", "2,ssrf,http://b.com:8080/?y=2"}},
+		{"{{.Host}} {{.Payload}}", "off", []string{"a.com 1", "b.com:8080 2"}},
+		{"{{.URL}}", "comment", []string{"http://a.com/?x=1 # tpl=cmd", "http://b.com:8080/?y=2 # tpl=ssrf"}},
+		{"{{.Index}}\t{{.URL}}", "param", []string{"1\thttp://a.com/?x=1&" + tagParam + "=cmd", "2\thttp://b.com:8080/?y=2&" + tagParam + "=ssrf"}},
+	}
+	defer func(tpl *template.Template, tag string, strict bool, dir string) {
+		lineTpl, tagMode, strictURL, saveReqDir, emitted = tpl, tag, strict, dir, 0
+	}(lineTpl, tagMode, strictURL, saveReqDir)
+	strictURL, saveReqDir = false, ""
+	for _, tt := range tests {
+		lineTpl = template.Must(template.New("line").Option("missingkey=error").Parse(tt.tpl))
+		tagMode, emitted = tt.tag, 0
+		f, err := os.Create(filepath.Join(t.TempDir(), "out.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		emit(f, nil, variant{URL: "http://a.com/?x=1", Payload: "1", Template: "cmd"})
+		emit(f, nil, variant{URL: "http://b.com:8080/?y=2", Payload: "2", Template: "ssrf"})
+		f.Close()
+		data, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("template %q tag=%s: got %q, want %q", tt.tpl, tt.tag, got, tt.want)
+		}
+	}
+}
+
+func tags(tpls []payloadTemplate) string {
+	var b strings.Builder
+	for _, t := range tpls {
+		b.WriteString(t.Tag + " ")
+	}
+	return b.String()
+}
+
+func TestPayloadOrder(t *testing.T) {
+	orig := tags(payloadTemplates)
+	tests := []struct {
+		seedA, seedB int64
+		wantSame     bool
+	}{
+		{1, 1, true},
+		{42, 42, true},
+		{1, 2, false},
+	}
+	for _, tt := range tests {
+		a, b := rand.New(rand.NewSource(tt.seedA)), rand.New(rand.NewSource(tt.seedB))
+		// Several lines in a row, as main draws one order per input line
+		for line := 0; line < 3; line++ {
+			ga, gb := tags(payloadOrder(a, payloadTemplates)), tags(payloadOrder(b, payloadTemplates))
+			if (ga == gb) != tt.wantSame {
+				t.Errorf("seeds %d/%d line %d: %q vs %q, same=%v, want %v", tt.seedA, tt.seedB, line, ga, gb, ga == gb, tt.wantSame)
+			}
+		}
+	}
+	if got := tags(payloadOrder(nil, payloadTemplates)); got != orig {
+		t.Errorf("payloadOrder(nil) = %q, want input order %q", got, orig)
+	}
+	if got := tags(payloadTemplates); got != orig {
+		t.Errorf("payloadOrder shuffled its input in place: %q", got)
+	}
+}
+
+func TestParseSweep(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"  ", "", false},
+		{"all", "plain url double case space comment ", false},
+		{"url, double", "url double ", false},
+		{"case,plain", "case plain ", false},
+		{"url,base64", "", true},
+		{"url,,plain", "", true},
+		{"URL", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseSweep(tt.spec)
+		var b strings.Builder
+		for _, c := range got {
+			b.WriteString(c + " ")
+		}
+		if (err != nil) != tt.wantErr || b.String() != tt.want {
+			t.Errorf("parseSweep(%q) = %q, %v, want %q (err %v)", tt.spec, b.String(), err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSweepPayloads(t *testing.T) {
+	tests := []struct {
+		classes []string
+		payload string
+		want    []sweptPayload
+	}{
+		{nil, ";%20id", []sweptPayload{{"", ";%20id"}}},
+		{sweepNames, ";%20id", []sweptPayload{
+			{"plain", ";%20id"},
+			{"url", "%3B%20id"},
+			{"double", "%253B%2520id"},
+			{"case", "%3B%20iD"},
+			{"space", "%3B%09id"},
+			{"comment", "%3B%2F%2A%2A%2Fid"},
+		}},
+		{[]string{"case", "url"}, "nslookup%20x", []sweptPayload{{"case", "nSlOoKuP%20x"}, {"url", "nslookup%20x"}}},
+		{[]string{"url"}, "100%", []sweptPayload{{"url", "100%25"}}}, // bad escape taken literally
+	}
+	defer func(c []string) { sweepClasses = c }(sweepClasses)
+	for _, tt := range tests {
+		sweepClasses = tt.classes
+		got := sweepPayloads(tt.payload)
+		if len(got) != len(tt.want) {
+			t.Errorf("classes %v: sweepPayloads(%q) = %v, want %v", tt.classes, tt.payload, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("classes %v: sweepPayloads(%q)[%d] = %v, want %v", tt.classes, tt.payload, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestAlternateCase(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"nslookup", "nSlOoKuP"},
+		{"NSLOOKUP", "nSlOoKuP"},
+		{"cat /etc/passwd", "cAt /EtC/pAsSwD"},
+		{"a1b2c", "a1B2c"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := alternateCase(tt.in); got != tt.want {
+			t.Errorf("alternateCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSweptPayloadSuffix(t *testing.T) {
+	tests := []struct {
+		sp   sweptPayload
+		want string
+	}{
+		{sweptPayload{payload: "x"}, ""},
+		{sweptPayload{class: "double", payload: "x"}, "+double"},
+	}
+	for _, tt := range tests {
+		if got := tt.sp.suffix(); got != tt.want {
+			t.Errorf("%+v.suffix() = %q, want %q", tt.sp, got, tt.want)
+		}
+	}
+}
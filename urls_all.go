@@ -2,14 +2,26 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
+	"flag"
 	"fmt"
+	"hash/fnv"
+	"html"
 	"io"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -19,6 +31,39 @@ const (
 	waybackAddr = "web.archive.org:443"
 )
 
+var (
+	cdxFields string
+	liveOnly  bool
+
+	retryMaxBackoff time.Duration
+	retryJitter     float64
+
+	cdxRate    float64
+	cdxLimiter *rateLimiter // shared by every CDX request, retries included
+
+	probeLive        bool
+	probeConcurrency int
+	probeTimeout     time.Duration
+	excludeStatus    statusSpec
+
+	limitPerHost int
+	retryOnEmpty bool
+
+	domainsFile          string
+	maxConcurrentDomains int
+	failFast             bool
+	maxFileSize          int64 // MiB
+
+	pipeGrep bool
+
+	quiet        bool
+	totalFetched int64
+
+	// Output files currently being written, synced on interrupt
+	openFilesMu sync.Mutex
+	openFiles   = make(map[*os.File]struct{})
+)
+
 // create HTTP client with timeouts
 func makeClient() *http.Client {
 	dialer := &net.Dialer{
@@ -45,7 +90,8 @@ func makeClient() *http.Client {
 	}
 }
 
-// retry backoff with jitter
+// retry backoff with jitter: exponential from 400ms, capped at -retry-max-backoff,
+// plus a random extra of up to -retry-jitter times the base delay
 func retryBackoff(attempt int) time.Duration {
 	if attempt <= 0 {
 		return 0
@@ -53,13 +99,18 @@ func retryBackoff(attempt int) time.Duration {
 	d := 400 * time.Millisecond
 	for i := 1; i < attempt; i++ {
 		d *= 2
-		if d > 6*time.Second {
-			d = 6 * time.Second
+		if d > retryMaxBackoff {
 			break
 		}
 	}
-	j := time.Duration(int64(d) / 5)
-	return d + time.Duration(time.Now().UnixNano()%int64(j))
+	if d > retryMaxBackoff {
+		d = retryMaxBackoff
+	}
+	j := int64(float64(d) * retryJitter)
+	if j <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(j+1))
 }
 
 // check if error or HTTP code is transient
@@ -79,59 +130,247 @@ func transient(err error, code int) bool {
 	return false
 }
 
-func fetchAllURLs(domain string) {
+// fetch every archived URL of domain into reports/{domain}_all.txt; the
+// spinner is only shown when a single fetch owns the terminal
+func fetchAllURLs(domain string, spinner bool) error {
 	client := makeClient()
 
-	cdxURL := fmt.Sprintf("https://%s/cdx/search/cdx?url=*.%s/*&collapse=urlkey&output=text&fl=original", waybackHost, domain)
+	cdxURL := fmt.Sprintf("https://%s/cdx/search/cdx?url=*.%s/*&collapse=urlkey&output=text&fl=%s", waybackHost, domain, cdxFields)
+
+	// Column of the statuscode field, if requested, for the status summary
+	statusIdx := fieldIndex("statuscode")
+	statusCounts := make(map[string]int)
+
+	// Per-host write counts for -limit-per-host
+	urlIdx := fieldIndex("original")
+	perHost := make(map[string]int)
+	truncated := make(map[string]int)
 
 	// Ensure reports directory
 	if err := os.MkdirAll("reports", os.ModePerm); err != nil {
-		fmt.Println("Error creating reports directory:", err)
-		return
+		return fmt.Errorf("creating reports directory: %w", err)
 	}
 
 	filePath := fmt.Sprintf("reports/%s_all.txt", domain)
+	if pipeGrep {
+		filePath = fmt.Sprintf("reports/%s_mutated.txt", domain)
+	}
+	grepSeen := make(map[uint64]struct{})
+	mutated := 0
 	file, err := os.Create(filePath)
 	if err != nil {
-		fmt.Println("Error creating output file:", err)
+		return fmt.Errorf("creating output file: %w", err)
+	}
+	trackFile(file)
+	defer untrackFile(file)
+
+	// Spinner for display
+	spinnerChars := []rune{'-', '\\', '|', '/'}
+	count := 0
+	spinnerIndex := 0
+	done := make(chan bool, 1)
+
+	if spinner && !quiet {
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					fmt.Printf("\r[%c] Fetched: %d URLs", spinnerChars[spinnerIndex], count)
+					spinnerIndex = (spinnerIndex + 1) % len(spinnerChars)
+					time.Sleep(100 * time.Millisecond)
+				}
+			}
+		}()
+	}
+
+	const maxAttempts = 5
+	emptyRetries := 0
+	for {
+		resp, err := requestCDX(client, cdxURL, maxAttempts)
+		if err != nil {
+			done <- true
+			return fmt.Errorf("fetching URLs: %w", err)
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		buf := make([]byte, 0, 128*1024)
+		scanner.Buffer(buf, 2*1024*1024) // allow long lines
+
+		received := 0
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			received++
+			if statusIdx >= 0 {
+				code := "-"
+				if cols := strings.Fields(line); statusIdx < len(cols) {
+					code = cols[statusIdx]
+				}
+				statusCounts[code]++
+				if liveOnly && !liveLikely(code) {
+					continue
+				}
+			}
+			if limitPerHost > 0 && urlIdx >= 0 {
+				host := lineHost(line, urlIdx)
+				if perHost[host] >= limitPerHost {
+					truncated[host]++
+					continue
+				}
+				perHost[host]++
+			}
+			count++
+			if pipeGrep {
+				cols := strings.Fields(line)
+				if urlIdx >= len(cols) {
+					continue
+				}
+				if out, ok := grepURL(cols[urlIdx], grepSeen); ok {
+					mutated++
+					_, _ = file.WriteString(out + "\n")
+				}
+				continue
+			}
+			_, _ = file.WriteString(line + "\n")
+		}
+		scanErr := scanner.Err()
+		resp.Body.Close()
+
+		// A 200 with an empty body is sometimes a transient CDX hiccup
+		if received == 0 && scanErr == nil && retryOnEmpty && emptyRetries < maxAttempts-1 {
+			emptyRetries++
+			time.Sleep(retryBackoff(emptyRetries))
+			continue
+		}
+
+		done <- true
+		if scanErr != nil {
+			return fmt.Errorf("reading response: %w", scanErr)
+		}
+		atomic.AddInt64(&totalFetched, int64(count))
+		say("\r[✓] Completed %s! Total: %d URLs\n", domain, count)
+		if pipeGrep {
+			say("Pipe-grep: %d fetched, %d mutated URLs written to %s\n", count, mutated, filePath)
+		}
+		if retryOnEmpty && emptyRetries > 0 {
+			if received == 0 {
+				say("Still empty after %d retries; the domain likely has no archived URLs\n", emptyRetries)
+			} else {
+				say("Got results after %d empty-response retries\n", emptyRetries)
+			}
+		}
+		break
+	}
+	if statusIdx >= 0 {
+		printStatusSummary(statusCounts)
+	}
+	if len(truncated) > 0 {
+		printTruncation(truncated)
+	}
+	return nil
+}
+
+func trackFile(f *os.File) {
+	openFilesMu.Lock()
+	openFiles[f] = struct{}{}
+	openFilesMu.Unlock()
+}
+
+func untrackFile(f *os.File) {
+	openFilesMu.Lock()
+	delete(openFiles, f)
+	openFilesMu.Unlock()
+	f.Close()
+}
+
+// say prints progress to stdout unless -quiet.
+func say(format string, a ...interface{}) {
+	if !quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+// warn prints a problem to stdout, or to stderr with -quiet so it is not lost.
+func warn(format string, a ...interface{}) {
+	if quiet {
+		fmt.Fprintf(os.Stderr, format, a...)
 		return
 	}
-	defer file.Close()
+	fmt.Printf(format, a...)
+}
 
-	// Handle interrupts gracefully
+// Handle interrupts gracefully: flush whatever is being written and exit
+func handleInterrupts() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-sigChan
-		fmt.Println("\nInterrupt received, saving progress...")
-		file.Sync()
+		say("\nInterrupt received, saving progress...\n")
+		openFilesMu.Lock()
+		for f := range openFiles {
+			f.Sync()
+		}
 		os.Exit(0)
 	}()
+}
 
-	// Spinner for display
-	spinnerChars := []rune{'-', '\\', '|', '/'}
-	count := 0
-	spinnerIndex := 0
-	done := make(chan bool)
+// process one domain: fetch, then optionally probe
+func runDomain(domain string, spinner bool) error {
+	if err := fetchAllURLs(domain, spinner); err != nil {
+		return err
+	}
+	if probeLive {
+		probeLiveURLs(domain)
+	}
+	return nil
+}
 
-	go func() {
-		for {
-			select {
-			case <-done:
-				return
-			default:
-				fmt.Printf("\r[%c] Fetched: %d URLs", spinnerChars[spinnerIndex], count)
-				spinnerIndex = (spinnerIndex + 1) % len(spinnerChars)
-				time.Sleep(100 * time.Millisecond)
-			}
+// process several domains, at most maxConcurrentDomains at a time, since they
+// all hit web.archive.org and too many in parallel just gets us 429'd
+func runDomains(domains []string) (failures map[string]error, abortedBy string, notStarted int) {
+	say("Processing %d domains, %d at a time\n", len(domains), maxConcurrentDomains)
+	sem := make(chan struct{}, maxConcurrentDomains)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failures = make(map[string]error)
+	for i, d := range domains {
+		sem <- struct{}{}
+		mu.Lock()
+		stop := abortedBy != ""
+		mu.Unlock()
+		if stop {
+			<-sem
+			notStarted = len(domains) - i
+			break
 		}
-	}()
-
-	var resp *http.Response
-	var reqErr error
+		wg.Add(1)
+		go func(domain string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := runDomain(domain, maxConcurrentDomains == 1); err != nil {
+				warn("\n[!] %s: %v\n", domain, err)
+				mu.Lock()
+				failures[domain] = err
+				if failFast && abortedBy == "" {
+					abortedBy = domain
+				}
+				mu.Unlock()
+			}
+		}(d)
+	}
+	wg.Wait()
+	return failures, abortedBy, notStarted
+}
 
-	const maxAttempts = 5
-	for attempt := 0; attempt < maxAttempts; attempt++ {
+// requestCDX issues the CDX query, retrying transient failures, and returns a
+// 2xx response whose body the caller must close
+func requestCDX(client *http.Client, cdxURL string, maxAttempts int) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		cdxLimiter.wait()
 		req, _ := http.NewRequest(http.MethodGet, cdxURL, nil)
 
 		// Add realistic headers
@@ -150,15 +389,15 @@ func fetchAllURLs(domain string) {
 		req.Header.Set("Upgrade-Insecure-Requests", "1")
 		req.Host = waybackHost
 
-		resp, reqErr = client.Do(req)
+		resp, err := client.Do(req)
 
 		var code int
 		if resp != nil {
 			code = resp.StatusCode
 		}
 
-		if reqErr == nil && code >= 200 && code < 300 {
-			break
+		if err == nil && code >= 200 && code < 300 {
+			return resp, nil
 		}
 
 		if resp != nil && resp.Body != nil {
@@ -166,52 +405,619 @@ func fetchAllURLs(domain string) {
 			resp.Body.Close()
 		}
 
-		if !transient(reqErr, code) || attempt == maxAttempts-1 {
-			if reqErr != nil {
-				fmt.Printf("\nError fetching URLs: %v\n", reqErr)
-			} else {
-				fmt.Printf("\nHTTP error fetching URLs: %d\n", code)
+		if !transient(err, code) || attempt == maxAttempts-1 {
+			if err != nil {
+				return nil, err
 			}
-			done <- true
-			return
+			return nil, fmt.Errorf("HTTP %d", code)
 		}
 
 		time.Sleep(retryBackoff(attempt + 1))
 	}
+}
 
-	if resp == nil {
-		done <- true
-		fmt.Println("\nFailed to get a response")
+// rateLimiter spaces calls to wait evenly at a fixed rate, shared by every
+// domain worker. A nil limiter never blocks.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(rps float64) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// wait blocks until the caller's slot comes up.
+func (l *rateLimiter) wait() {
+	if l == nil {
 		return
 	}
-	defer resp.Body.Close()
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+// fieldIndex returns the column of a CDX field in -fields, or -1
+func fieldIndex(name string) int {
+	for i, f := range strings.Split(cdxFields, ",") {
+		if f == name {
+			return i
+		}
+	}
+	return -1
+}
 
-	scanner := bufio.NewScanner(resp.Body)
-	buf := make([]byte, 0, 128*1024)
-	scanner.Buffer(buf, 2*1024*1024) // allow long lines
+// lineHost extracts the lowercased host of the URL in column idx of a CDX line
+func lineHost(line string, idx int) string {
+	cols := strings.Fields(line)
+	if idx >= len(cols) {
+		return ""
+	}
+	u, err := url.Parse(cols[idx])
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+// printTruncation lists hosts that hit -limit-per-host, most truncated first
+func printTruncation(truncated map[string]int) {
+	hosts := make([]string, 0, len(truncated))
+	total := 0
+	for h, n := range truncated {
+		hosts = append(hosts, h)
+		total += n
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if truncated[hosts[i]] != truncated[hosts[j]] {
+			return truncated[hosts[i]] > truncated[hosts[j]]
 		}
-		count++
-		_, _ = file.WriteString(line + "\n")
+		return hosts[i] < hosts[j]
+	})
+	say("Per-host limit %d skipped %d URLs on %d hosts:\n", limitPerHost, total, len(hosts))
+	for _, h := range hosts {
+		say("  %s: %d skipped\n", h, truncated[h])
 	}
+}
 
-	done <- true
-	if err := scanner.Err(); err != nil {
-		fmt.Println("\nError reading response:", err)
-	} else {
-		fmt.Printf("\r[✓] Completed! Total: %d URLs\n", count)
+// liveLikely reports whether an archived status suggests the URL may still be live.
+func liveLikely(code string) bool {
+	return len(code) == 3 && (code[0] == '2' || code[0] == '3')
+}
+
+// printStatusSummary prints archived captures grouped by HTTP status, most common first.
+func printStatusSummary(counts map[string]int) {
+	codes := make([]string, 0, len(counts))
+	for c := range counts {
+		codes = append(codes, c)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if counts[codes[i]] != counts[codes[j]] {
+			return counts[codes[i]] > counts[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	say("Archived status breakdown:\n")
+	for _, c := range codes {
+		say("  %s: %d\n", c, counts[c])
+	}
+	if liveOnly {
+		say("  (only 2xx/3xx captures were written)\n")
 	}
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: go run yourfile.go <domain>")
+	flag.Int64Var(&maxFileSize, "max-file-size", 512, "Refuse input files larger than this many MiB, and cap piped input at it (0 = no limit)")
+	flag.StringVar(&cdxFields, "fields", "original", "comma-separated CDX fields to fetch, e.g. original,statuscode,timestamp")
+	flag.BoolVar(&liveOnly, "live-only", false, "with statuscode in -fields, write only captures archived as 2xx/3xx")
+	flag.Float64Var(&cdxRate, "rate", 1, "max CDX requests per second across all domains, retries and empty-result refetches included (0 = unlimited)")
+	flag.DurationVar(&retryMaxBackoff, "retry-max-backoff", 6*time.Second, "cap on the exponential retry delay")
+	flag.Float64Var(&retryJitter, "retry-jitter", 0.2, "random extra delay per retry, as a fraction of the backoff (0 disables)")
+	flag.BoolVar(&probeLive, "probe-live", false, "after fetching, probe each URL and write the ones answering 2xx/3xx to reports/{domain}_live.txt")
+	flag.IntVar(&probeConcurrency, "probe-concurrency", 20, "concurrent requests during -probe-live")
+	flag.DurationVar(&probeTimeout, "probe-timeout", 10*time.Second, "per-request timeout during -probe-live")
+	flag.IntVar(&limitPerHost, "limit-per-host", 0, "write at most N URLs per host so a few busy subdomains don't bury the rest (0 = no limit)")
+	flag.StringVar(&domainsFile, "l", "", "file of domains to fetch, one per line (the <domain> argument becomes optional)")
+	flag.IntVar(&maxConcurrentDomains, "max-concurrent-domains", 2, "with -l, how many domains to fetch at once; keep it low, the Archive rate-limits")
+	flag.BoolVar(&quiet, "quiet", false, "no spinner or progress on stdout; errors and a one-line summary go to stderr")
+	flag.BoolVar(&pipeGrep, "pipe-grep", false, "run greper's default filter and mutation on each URL as it streams in, writing reports/<domain>_mutated.txt instead of _all.txt")
+	flag.BoolVar(&failFast, "fail-fast", false, "with -l, stop starting new domains after the first one fails (in-flight domains still finish); exits non-zero")
+	flag.BoolVar(&retryOnEmpty, "retry-on-empty", false, "retry the whole fetch when the CDX answers 200 with no results, before concluding the domain has none")
+	excludeSpec := flag.String("exclude-status", "", "with -probe-live, leave URLs answering these statuses out of the live file, e.g. 404,403,300-399")
+	showCfg := flag.Bool("show-config", false, "print the effective settings to stderr and exit")
+	flag.Parse()
+	if *showCfg {
+		showConfig()
+		return
+	}
+
+	if flag.NArg() < 1 && domainsFile == "" {
+		fmt.Println("Usage: go run urls_all.go [-l domains.txt [-max-concurrent-domains 2]] [-fields original,statuscode] [-live-only] [-rate 1] [-retry-max-backoff 6s] [-retry-jitter 0.2] [-probe-live [-exclude-status 404,403]] [-limit-per-host N] [-retry-on-empty] [-pipe-grep] [-fail-fast] [-quiet] [-show-config] <domain>")
+		os.Exit(1)
+	}
+	var err error
+	if excludeStatus, err = parseStatusSpec(*excludeSpec); err != nil {
+		fmt.Println("Invalid -exclude-status:", err)
+		os.Exit(1)
+	}
+	if retryMaxBackoff <= 0 || retryJitter < 0 {
+		fmt.Println("-retry-max-backoff must be positive and -retry-jitter must not be negative")
+		os.Exit(1)
+	}
+	if probeConcurrency < 1 || probeTimeout <= 0 {
+		fmt.Println("-probe-concurrency must be at least 1 and -probe-timeout positive")
+		os.Exit(1)
+	}
+	cdxFields = strings.ReplaceAll(cdxFields, " ", "")
+	if liveOnly && fieldIndex("statuscode") < 0 {
+		fmt.Println("-live-only requires statuscode in -fields")
+		os.Exit(1)
+	}
+	if limitPerHost > 0 && fieldIndex("original") < 0 {
+		fmt.Println("-limit-per-host requires original in -fields")
+		os.Exit(1)
+	}
+	if pipeGrep && fieldIndex("original") < 0 {
+		fmt.Println("-pipe-grep requires original in -fields")
+		os.Exit(1)
+	}
+	if pipeGrep && probeLive {
+		fmt.Println("-pipe-grep writes mutated URLs only, so it cannot be combined with -probe-live")
+		os.Exit(1)
+	}
+	if maxConcurrentDomains < 1 {
+		fmt.Println("-max-concurrent-domains must be at least 1")
+		os.Exit(1)
+	}
+	if cdxRate < 0 {
+		fmt.Println("-rate must not be negative")
+		os.Exit(1)
+	}
+	cdxLimiter = newRateLimiter(cdxRate)
+	if cdxRate > 0 {
+		say("CDX rate limit: %g requests/s across all domains\n", cdxRate)
+	} else {
+		say("CDX rate limit: none (-rate 0)\n")
+	}
+
+	handleInterrupts()
+
+	if domainsFile == "" {
+		domain, err := normalizeDomain(flag.Arg(0))
+		if err != nil {
+			fmt.Println("Invalid domain:", err)
+			os.Exit(1)
+		}
+		if domain != flag.Arg(0) {
+			say("Using domain %s\n", domain)
+		}
+		if err := runDomain(domain, true); err != nil {
+			warn("\nError: %v\n", err)
+			os.Exit(1)
+		}
+		if quiet {
+			fmt.Fprintf(os.Stderr, "urls_all: %s: %d URLs fetched\n", domain, totalFetched)
+		}
+		return
+	}
+
+	lines, err := readLines(domainsFile)
+	if err != nil {
+		fmt.Println("Error reading domains file:", err)
+		os.Exit(1)
+	}
+	var domains []string
+	seen := make(map[string]struct{})
+	invalid := 0
+	for _, l := range append(lines, flag.Args()...) {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		d, err := normalizeDomain(l)
+		if err != nil {
+			warn("Skipping invalid domain: %v\n", err)
+			invalid++
+			continue
+		}
+		if _, ok := seen[d]; !ok {
+			seen[d] = struct{}{}
+			domains = append(domains, d)
+		}
+	}
+	failures, abortedBy, notStarted := runDomains(domains)
+	if abortedBy != "" {
+		say("Aborted by -fail-fast: %s failed; %d domains not started (output of finished domains is complete)\n", abortedBy, notStarted)
+	}
+	say("Done: %d domains processed, %d failed, %d invalid skipped (concurrency %d)\n", len(domains)-notStarted, len(failures), invalid, maxConcurrentDomains)
+	for d, err := range failures {
+		say("  %s: %v\n", d, err)
+	}
+	if quiet {
+		fmt.Fprintf(os.Stderr, "urls_all: %d domains, %d failed, %d URLs fetched\n", len(domains)-notStarted, len(failures), totalFetched)
+	}
+	if len(failures) > 0 {
 		os.Exit(1)
 	}
-	domain := os.Args[1]
-	fetchAllURLs(domain)
+}
+
+// read non-empty lines of a file
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := guardInput(f)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 128*1024), 2*1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// guardInput checks an opened input against -max-file-size before it is
+// read: regular files are refused when too large or when they look binary
+// (a NUL in the first 4 KiB); pipes are capped as they are read.
+func guardInput(f *os.File) (io.Reader, error) {
+	limit := maxFileSize << 20
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !st.Mode().IsRegular() {
+		if limit <= 0 {
+			return f, nil
+		}
+		return &cappedReader{r: f, left: limit, name: f.Name()}, nil
+	}
+	if limit > 0 && st.Size() > limit {
+		return nil, fmt.Errorf("%s is %d MiB, over -max-file-size %d MiB (raise it, or 0 to disable)", f.Name(), st.Size()>>20, maxFileSize)
+	}
+	head := make([]byte, 4096)
+	n, _ := io.ReadFull(f, head)
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		return nil, fmt.Errorf("%s looks like a binary file, not a text list", f.Name())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// cappedReader fails a stream once it passes -max-file-size.
+type cappedReader struct {
+	r    io.Reader
+	left int64
+	name string
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		return 0, fmt.Errorf("%s exceeds -max-file-size %d MiB", c.name, maxFileSize)
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	return n, err
+}
+
+var domainRe = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// normalizeDomain reduces a URL-ish or host-ish argument (scheme, userinfo,
+// port, path, query, leading "*." or trailing dot) to the bare lowercase host
+// the CDX query expects, and rejects anything that doesn't look like a domain
+func normalizeDomain(arg string) (string, error) {
+	d := strings.TrimSpace(arg)
+	if i := strings.Index(d, "://"); i >= 0 {
+		d = d[i+3:]
+	}
+	if i := strings.IndexAny(d, "/?#"); i >= 0 {
+		d = d[:i]
+	}
+	if i := strings.LastIndex(d, "@"); i >= 0 {
+		d = d[i+1:]
+	}
+	if h, _, err := net.SplitHostPort(d); err == nil {
+		d = h
+	}
+	d = strings.TrimPrefix(d, "*.")
+	d = strings.TrimSuffix(strings.ToLower(d), ".")
+	if !domainRe.MatchString(d) || net.ParseIP(d) != nil {
+		return "", fmt.Errorf("%q does not look like a domain (expected e.g. example.com)", arg)
+	}
+	return d, nil
+}
+
+// create HTTP client for probing targets: no Wayback SNI pinning and redirects
+// are not followed, since a 3xx already proves the URL is live
+func makeProbeClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   7 * time.Second,
+		KeepAlive: 60 * time.Second,
+	}
+	trans := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          200,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   7 * time.Second,
+		ExpectContinueTimeout: 2 * time.Second,
+		TLSClientConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	return &http.Client{
+		Transport: trans,
+		Timeout:   probeTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// probeLiveURLs checks which archived URLs of domain still answer and writes
+// them to reports/{domain}_live.txt
+func probeLiveURLs(domain string) {
+	urlIdx := fieldIndex("original")
+	if urlIdx < 0 {
+		warn("Skipping live probe: -fields does not include original\n")
+		return
+	}
+
+	in, err := os.Open(fmt.Sprintf("reports/%s_all.txt", domain))
+	if err != nil {
+		warn("Error opening fetched URLs: %v\n", err)
+		return
+	}
+	defer in.Close()
+
+	livePath := fmt.Sprintf("reports/%s_live.txt", domain)
+	out, err := os.Create(livePath)
+	if err != nil {
+		warn("Error creating live file: %v\n", err)
+		return
+	}
+	defer out.Close()
+
+	client := makeProbeClient()
+	sem := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var live, dead, excluded int64
+	seen := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 128*1024), 2*1024*1024)
+	for scanner.Scan() {
+		cols := strings.Fields(scanner.Text())
+		if urlIdx >= len(cols) {
+			continue
+		}
+		target := cols[urlIdx]
+		if _, ok := seen[target]; ok {
+			continue
+		}
+		seen[target] = struct{}{}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(u string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			code, err := probeURL(client, u)
+			if err != nil || code < 200 || code >= 400 {
+				atomic.AddInt64(&dead, 1)
+				return
+			}
+			atomic.AddInt64(&live, 1)
+			if excludeStatus.contains(code) {
+				atomic.AddInt64(&excluded, 1)
+				return
+			}
+			mu.Lock()
+			_, _ = out.WriteString(u + "\n")
+			mu.Unlock()
+		}(target)
+	}
+	wg.Wait()
+	if err := scanner.Err(); err != nil {
+		warn("Error reading fetched URLs: %v\n", err)
+	}
+	say("Live probe: %d live, %d dead; live URLs written to %s\n", live, dead, livePath)
+	if excludeStatus != nil {
+		say("Left out by -exclude-status: %d of the live URLs\n", excluded)
+	}
+}
+
+// statusSpec is a set of status codes and inclusive ranges, parsed from
+// specs like "404,403,500-599". A nil spec contains nothing.
+type statusSpec [][2]int
+
+func parseStatusSpec(spec string) (statusSpec, error) {
+	var out statusSpec
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		a, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("bad status %q", part)
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil || b < a {
+				return nil, fmt.Errorf("bad status range %q", part)
+			}
+		}
+		out = append(out, [2]int{a, b})
+	}
+	return out, nil
+}
+
+func (s statusSpec) contains(code int) bool {
+	for _, r := range s {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// probeURL sends a HEAD request, falling back to GET for servers that reject HEAD
+func probeURL(client *http.Client, u string) (int, error) {
+	code, err := probeOnce(client, http.MethodHead, u)
+	if err == nil && (code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented) {
+		return probeOnce(client, http.MethodGet, u)
+	}
+	return code, err
+}
+
+func probeOnce(client *http.Client, method, u string) (int, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// showConfig prints the effective value of every flag to stderr, marking
+// the ones left at their default.
+func showConfig() {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	fmt.Fprintln(os.Stderr, "Effective config:")
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "show-config" {
+			return
+		}
+		src := "default"
+		if set[f.Name] {
+			src = "set"
+		}
+		fmt.Fprintf(os.Stderr, "  -%-24s %-30q (%s)\n", f.Name, f.Value.String(), src)
+	})
+}
+
+// grepURL is greper's default pass over one URL (same rules as
+// go run greper.go with no flags): unescape HTML entities, require a
+// key=value query, drop duplicates, static assets and analytics-only
+// queries, then replace each remaining value with LAKSH1..N. seen holds
+// 64-bit hashes of the URLs so memory stays small on huge domains.
+func grepURL(raw string, seen map[uint64]struct{}) (string, bool) {
+	u, err := url.Parse(html.UnescapeString(raw))
+	if err != nil || u.Scheme == "" || u.Host == "" || !hasKeyValueQuery(u.RawQuery) {
+		return "", false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(u.String()))
+	sum := h.Sum64()
+	if _, ok := seen[sum]; ok {
+		return "", false
+	}
+	seen[sum] = struct{}{}
+	if looksLikeAsset(u.Path) || !hasAnyNonBlacklistedKey(u.RawQuery) {
+		return "", false
+	}
+	u.RawQuery = mutateQueryRaw(u.RawQuery)
+	return u.String(), true
+}
+
+// hasKeyValueQuery checks if the raw query contains at least one key=value pair.
+func hasKeyValueQuery(raw string) bool {
+	for _, p := range splitParams(raw) {
+		if i := strings.IndexByte(p, '='); i > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAnyNonBlacklistedKey returns true if raw query has at least one key not in the blacklist.
+func hasAnyNonBlacklistedKey(raw string) bool {
+	for _, p := range splitParams(raw) {
+		if !isBlacklistedKey(strings.SplitN(p, "=", 2)[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitParams splits on & and ; to cover both separators conservatively.
+func splitParams(raw string) []string {
+	return strings.FieldsFunc(raw, func(r rune) bool {
+		return r == '&' || r == ';'
+	})
+}
+
+// Analytics/attribution blacklist, kept in sync with greper.go.
+var analyticsBlacklist = map[string]struct{}{
+	"utm_source": {}, "utm_medium": {}, "utm_campaign": {}, "utm_term": {}, "utm_content": {},
+	"gclid": {}, "gclsrc": {}, "dclid": {}, "fbclid": {},
+	"msclkid": {}, "ttclid": {},
+	"pk_campaign": {}, "pk_source": {}, "pk_kwd": {},
+	"ref": {}, "ref_src": {}, "cid": {}, "campaign_id": {}, "mc_cid": {}, "mc_eid": {},
+}
+
+func isBlacklistedKey(k string) bool {
+	_, ok := analyticsBlacklist[strings.ToLower(k)]
+	return ok
+}
+
+// mutateQueryRaw replaces each non-blacklisted param value with LAKSH1..N;
+// blacklisted params keep their values.
+func mutateQueryRaw(raw string) string {
+	parts := splitParams(raw)
+	idx := 1
+	for i, p := range parts {
+		kv := strings.SplitN(p, "=", 2)
+		if isBlacklistedKey(kv[0]) {
+			continue
+		}
+		parts[i] = kv[0] + "=" + url.QueryEscape("LAKSH"+strconv.Itoa(idx))
+		idx++
+	}
+	return strings.Join(parts, "&")
+}
+
+func looksLikeAsset(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".js", ".mjs", ".css",
+		".png", ".jpg", ".jpeg", ".gif", ".webp", ".svg", ".ico", ".avif",
+		".mp4", ".webm", ".mp3", ".wav", ".ogg",
+		".woff", ".woff2", ".ttf", ".eot", ".otf",
+		".map", ".json":
+		return true
+	}
+	return false
 }